@@ -0,0 +1,89 @@
+package cloud
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Catalog caches the zones, instance types and templates, which rarely change,
+// for the given time to live.
+type Catalog struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]catalogEntry
+}
+
+type catalogEntry struct {
+	value   any
+	expires time.Time
+}
+
+func NewCatalog(ttl time.Duration) *Catalog {
+	return &Catalog{ttl: ttl, entries: make(map[string]catalogEntry)}
+}
+
+// Zones are cached per cloud, as are all entries.
+func (c *Catalog) Zones(ctx context.Context, a *Access) ([]string, error) {
+	return cached(ctx, c, "zones/"+a.Cloud, a.ListZones)
+}
+
+// InstanceTypes are cached per zone.
+func (c *Catalog) InstanceTypes(ctx context.Context, a *Access) ([]InstanceType, error) {
+	return cached(ctx, c, "instance-types/"+a.Cloud+"/"+a.Zone, a.ListInstanceTypes)
+}
+
+// Templates are cached per zone and API key, since private templates belong
+// to the tenant.
+func (c *Catalog) Templates(ctx context.Context, a *Access) ([]Template, error) {
+	return cached(ctx, c, "templates/"+a.Cloud+"/"+a.Zone+"/"+a.Key, a.ListTemplates)
+}
+
+// FindInstanceType returns the instance type given as family and size, or nil
+// if the zone does not offer it.
+func (c *Catalog) FindInstanceType(ctx context.Context, a *Access, name string) (*InstanceType, error) {
+	types, err := c.InstanceTypes(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		if t.Name == name {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindTemplate returns the template with the given ID, or nil if the tenant
+// cannot use it.
+func (c *Catalog) FindTemplate(ctx context.Context, a *Access, id string) (*Template, error) {
+	templates, err := c.Templates(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		if t.ID == id {
+			return &t, nil
+		}
+	}
+	return nil, nil
+}
+
+// cached returns the value stored under the key, unless it expired, in which
+// case it is loaded and stored again. Failed loads are not cached.
+func cached[T any](ctx context.Context, c *Catalog, key string, load func(context.Context) (T, error)) (T, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value.(T), nil
+	}
+	value, err := load(ctx)
+	if err != nil {
+		return value, err
+	}
+	c.mu.Lock()
+	c.entries[key] = catalogEntry{value: value, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return value, nil
+}
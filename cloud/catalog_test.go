@@ -0,0 +1,76 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCached(t *testing.T) {
+	catalog := NewCatalog(time.Hour)
+	loads := 0
+	load := func(context.Context) ([]string, error) {
+		loads++
+		return []string{"ch-gva-2"}, nil
+	}
+	for range 3 {
+		zones, err := cached(context.Background(), catalog, "zones", load)
+		if err != nil || len(zones) != 1 {
+			t.Fatalf("expected one zone, got %v (%v)", zones, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected one load within the time to live, got %d", loads)
+	}
+
+	catalog.ttl = 0
+	cached(context.Background(), catalog, "other", load)
+	cached(context.Background(), catalog, "other", load)
+	if loads != 3 {
+		t.Errorf("expected expired entries to be loaded again, got %d loads", loads)
+	}
+
+	failing := func(context.Context) ([]string, error) {
+		loads++
+		return nil, errors.New("unavailable")
+	}
+	catalog.ttl = time.Hour
+	for range 2 {
+		if _, err := cached(context.Background(), catalog, "failing", failing); err == nil {
+			t.Errorf("expected error to be passed on")
+		}
+	}
+	if loads != 5 {
+		t.Errorf("expected failed loads not to be cached, got %d loads", loads)
+	}
+}
+
+// zoneLister is a provider listing the given zones only.
+type zoneLister struct {
+	Provider
+	zones []string
+}
+
+func (z zoneLister) ListZones(context.Context) ([]string, error) {
+	return z.zones, nil
+}
+
+func TestCatalogSeparatesClouds(t *testing.T) {
+	catalog := NewCatalog(time.Hour)
+	factory := func(creds Credentials) Provider {
+		return zoneLister{zones: []string{creds.Provider + "-zone"}}
+	}
+	exoscale := NewAccess(factory, "alice", Credentials{Provider: "exoscale", Zone: "zone"})
+	openstack := NewAccess(factory, "bob", Credentials{Provider: "openstack", Zone: "zone", Endpoint: "https://cloud.example.com"})
+	for _, access := range []*Access{exoscale, openstack, exoscale} {
+		zones, err := catalog.Zones(context.Background(), access)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := access.Provider.(zoneLister).zones; !slices.Equal(zones, expected) {
+			t.Errorf("expected zones %v, got %v", expected, zones)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Package cloud defines the operations Cloud Castle carries out on the
+// instances of a cloud provider, independently of the provider.
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned by the methods of a Provider can be matched against these
+// using errors.Is, to tell the causes apart that callers can act upon.
+var (
+	// ErrNotFound is returned for resources that do not exist (anymore).
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is returned if the credentials are invalid or lack the
+	// permission for the call.
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited is returned if the provider still rejected the call for
+	// too many requests after all retries.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnavailable is returned if the provider failed or did not respond in
+	// time.
+	ErrUnavailable = errors.New("unavailable")
+	// ErrUnsupported is returned for operations the provider does not offer.
+	ErrUnsupported = errors.New("not supported")
+)
+
+// Provider carries out operations in one zone of a cloud, using the
+// credentials of a tenant.
+type Provider interface {
+	GetInstances(ctx context.Context) ([]*Instance, error)
+	GetInstance(ctx context.Context, id string) (*Instance, error)
+	// GetInstanceDetail returns the instance with the names of the resources
+	// it refers to resolved.
+	GetInstanceDetail(ctx context.Context, id string) (*Instance, error)
+	// GetInstanceTypeName returns the type of the instance as family and
+	// size, e.g. "standard.small".
+	GetInstanceTypeName(ctx context.Context, id string) (string, error)
+	// CreateInstance creates and starts an instance and waits until it
+	// exists.
+	CreateInstance(ctx context.Context, spec InstanceSpec) (*Instance, error)
+	// DeleteInstance destroys the instance and waits until it is gone.
+	DeleteInstance(ctx context.Context, id string) error
+	// UpdateInstanceLabels replaces the labels of the instance.
+	UpdateInstanceLabels(ctx context.Context, id string, labels map[string]string) error
+
+	// StartInstance, StopInstance and RebootInstance request the action and
+	// return the ID of the operation carrying it out, which can be awaited
+	// using WaitForOperation.
+	StartInstance(ctx context.Context, id string) (string, error)
+	StopInstance(ctx context.Context, id string) (string, error)
+	RebootInstance(ctx context.Context, id string) (string, error)
+	// WaitForOperation waits until the operation succeeded, or returns an
+	// error if it failed or did not finish within the timeout.
+	WaitForOperation(ctx context.Context, id string, timeout time.Duration) error
+	// ForceStopInstance stops the instance and waits for it to be stopped,
+	// repeating the stop if it does not succeed within the timeout.
+	ForceStopInstance(ctx context.Context, id string, timeout time.Duration) error
+	// ResetInstance reinstalls the instance from the template, or from the
+	// template it was created from if templateId is empty, and returns the ID
+	// of the template used. The user-data, if not empty, replaces the one the
+	// instance was created with.
+	ResetInstance(ctx context.Context, id, templateId, userData string) (string, error)
+	// ScaleInstance changes the type of the stopped instance to the one given
+	// as family and size.
+	ScaleInstance(ctx context.Context, id, instanceType string) error
+
+	ResetInstancePassword(ctx context.Context, id string) error
+	RevealInstancePassword(ctx context.Context, id string) (string, error)
+	GetConsoleURL(ctx context.Context, id string) (*ConsoleURL, error)
+
+	// CreateSnapshot takes a snapshot of the instance's disk, waits until it
+	// is done and returns the snapshot's ID.
+	CreateSnapshot(ctx context.Context, id string) (string, error)
+	GetSnapshots(ctx context.Context, instanceId string) ([]*Snapshot, error)
+	GetSnapshot(ctx context.Context, id string) (*Snapshot, error)
+	RevertToSnapshot(ctx context.Context, instanceId, snapshotId string) error
+	DeleteSnapshot(ctx context.Context, id string) error
+
+	// EnsureInstanceSecurityGroup returns the ID of the instance's own
+	// security group, creating it and attaching it if necessary.
+	EnsureInstanceSecurityGroup(ctx context.Context, instanceId string) (string, error)
+	// AddIngressRule adds the rule to the security group and returns the ID
+	// of the rule.
+	AddIngressRule(ctx context.Context, groupId string, rule IngressRule) (string, error)
+	DeleteSecurityGroupRule(ctx context.Context, groupId, ruleId string) error
+	// DeleteInstanceSecurityGroup removes the instance's own security group,
+	// if there is one.
+	DeleteInstanceSecurityGroup(ctx context.Context, instanceId string) error
+
+	// EnsureSSHKey registers the public key under the name, unless a key of
+	// that name is registered already. Keys are shared by all zones.
+	EnsureSSHKey(ctx context.Context, name, publicKey string) error
+	DeleteSSHKey(ctx context.Context, name string) error
+
+	ListZones(ctx context.Context) ([]string, error)
+	// ListInstanceTypes returns the instance types offered in the zone.
+	ListInstanceTypes(ctx context.Context) ([]InstanceType, error)
+	// ListTemplates returns the public templates and those private to the
+	// tenant.
+	ListTemplates(ctx context.Context) ([]Template, error)
+}
+
+// Credentials of a tenant for one zone of a cloud.
+type Credentials struct {
+	// Provider is the kind of cloud, e.g. "exoscale" or "openstack".
+	Provider string
+	Zone     string
+	// Endpoint is the URL of the cloud's API, for providers that are not
+	// bound to a single one.
+	Endpoint string
+	Key      string
+	Secret   string
+}
+
+// Factory creates a provider for the zone, using the given credentials.
+type Factory func(creds Credentials) Provider
+
+// Providers maps the kinds of cloud to the factories of their providers.
+type Providers map[string]Factory
+
+// Access returns an access to the cloud the credentials are for, using the
+// factory registered for its kind.
+func (p Providers) Access(username string, creds Credentials) (*Access, error) {
+	factory, ok := p[creds.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider '%s'", creds.Provider)
+	}
+	return NewAccess(factory, username, creds), nil
+}
+
+// Access is a provider used on behalf of a user, or of no user in particular
+// if Username is empty.
+type Access struct {
+	Provider
+	Username string
+	Zone     string
+	// Cloud identifies the cloud the provider is used with, i.e. its kind and
+	// endpoint, e.g. to cache resources of clouds whose zones share names
+	// apart.
+	Cloud string
+	// Key identifies the credentials, e.g. to cache resources private to the
+	// tenant.
+	Key string
+}
+
+func NewAccess(factory Factory, username string, creds Credentials) *Access {
+	return &Access{
+		Provider: factory(creds),
+		Username: username,
+		Zone:     creds.Zone,
+		Cloud:    creds.Provider + " " + creds.Endpoint,
+		Key:      creds.Key,
+	}
+}
+
+type Instance struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+	IP     string            `json:"ip"`
+	State  string            `json:"state"`
+	IPv6   string            `json:"ipv6,omitempty"`
+	Zone   string            `json:"zone"`
+	// Created is the zero time for instances that were just created.
+	Created      time.Time  `json:"created"`
+	InstanceType *Reference `json:"instance_type,omitempty"`
+	Template     *Reference `json:"template,omitempty"`
+	// DiskSize is given in GiB, and only known for single instances.
+	DiskSize        int64       `json:"disk_size,omitempty"`
+	SecurityGroups  []Reference `json:"security_groups,omitempty"`
+	PrivateNetworks []Reference `json:"private_networks,omitempty"`
+	SSHKeys         []string    `json:"ssh_keys,omitempty"`
+}
+
+// Reference identifies a resource used by an instance by its ID and, once
+// resolved, by its name.
+type Reference struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// InstanceSpec describes an instance to be created.
+type InstanceSpec struct {
+	Name string
+	// TemplateID is the UUID of the template to install.
+	TemplateID string
+	// InstanceType is given as family and size, e.g. "standard.small".
+	InstanceType string
+	// DiskSize is given in GiB.
+	DiskSize int64
+	// SecurityGroups are given by name or ID.
+	SecurityGroups []string
+	// UserData is the plain cloud-init user-data.
+	UserData string
+	Labels   map[string]string
+	// SSHKeys are the names of the registered SSH keys to be authorized.
+	SSHKeys []string
+}
+
+// ConsoleURL is a signed URL to the VNC console of an instance, to be opened
+// through the console proxy's websocket before it expires.
+type ConsoleURL struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+type Snapshot struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	InstanceID string    `json:"instance_id"`
+	Created    time.Time `json:"created"`
+	Size       int64     `json:"size"`
+	State      string    `json:"state"`
+}
+
+// IngressRule allows incoming traffic on a port from a network.
+type IngressRule struct {
+	// Protocol is either "tcp" or "udp".
+	Protocol string
+	Port     int64
+	// Network is given in CIDR notation.
+	Network string
+	// Description identifies the rule within its security group.
+	Description string
+}
+
+// InstanceType is an instance type offered in a zone.
+type InstanceType struct {
+	ID string `json:"id"`
+	// Name is given as family and size, e.g. "standard.small".
+	Name string `json:"name"`
+	CPUs int64  `json:"cpus"`
+	// Memory is given in bytes.
+	Memory int64 `json:"memory"`
+	GPUs   int64 `json:"gpus,omitempty"`
+	// Authorized is false for types the organization has to request first.
+	Authorized bool `json:"authorized"`
+}
+
+// Template is an operating system image, either public or private to the
+// tenant.
+type Template struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Family      string `json:"family"`
+	DefaultUser string `json:"default_user"`
+	Visibility  string `json:"visibility"`
+	// Size is given in bytes.
+	Size int64 `json:"size"`
+}
@@ -0,0 +1,728 @@
+// Package fake provides an in-memory cloud provider for tests, which simulates
+// the latency of calls, the state transitions of instances and failures.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// Cloud holds one provider per zone, so that all accesses to a zone share its
+// instances.
+type Cloud struct {
+	mu        sync.Mutex
+	providers map[string]*Provider
+}
+
+func NewCloud() *Cloud {
+	return &Cloud{providers: make(map[string]*Provider)}
+}
+
+// NewProvider is the cloud.Factory of the fake cloud; the credentials are
+// ignored.
+func (c *Cloud) NewProvider(creds cloud.Credentials) cloud.Provider {
+	return c.Zone(creds.Zone)
+}
+
+// Zone returns the provider of the zone, creating it if necessary.
+func (c *Cloud) Zone(zone string) *Provider {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	provider, ok := c.providers[zone]
+	if !ok {
+		provider = New(zone)
+		c.providers[zone] = provider
+	}
+	return provider
+}
+
+// Provider keeps the instances, snapshots, security groups and SSH keys of one
+// zone in memory. Actions take effect after Delay, during which instances are in a
+// transitional state such as "starting".
+type Provider struct {
+	// Latency is added to every call.
+	Latency time.Duration
+	// Delay is how long operations take until they take effect.
+	Delay time.Duration
+
+	Zones         []string
+	InstanceTypes []cloud.InstanceType
+	Templates     []cloud.Template
+
+	mu         sync.Mutex
+	zone       string
+	nextId     int
+	instances  map[string]*instance
+	snapshots  map[string]*cloud.Snapshot
+	groups     map[string]*securityGroup
+	sshKeys    map[string]string
+	operations map[string]*operation
+	failures   map[string]error
+}
+
+var _ cloud.Provider = (*Provider)(nil)
+
+type instance struct {
+	cloud.Instance
+	password string
+}
+
+type securityGroup struct {
+	id         string
+	instanceId string
+	rules      map[string]cloud.IngressRule
+}
+
+type operation struct {
+	done    time.Time
+	applied bool
+	apply   func()
+}
+
+// New creates an empty provider for the zone, offering the instance type
+// "standard.small" and the template "debian".
+func New(zone string) *Provider {
+	p := &Provider{
+		Zones:      []string{zone},
+		zone:       zone,
+		instances:  make(map[string]*instance),
+		snapshots:  make(map[string]*cloud.Snapshot),
+		groups:     make(map[string]*securityGroup),
+		sshKeys:    make(map[string]string),
+		operations: make(map[string]*operation),
+		failures:   make(map[string]error),
+	}
+	p.InstanceTypes = []cloud.InstanceType{
+		{ID: p.newId(), Name: "standard.small", CPUs: 2, Memory: 2 << 30, Authorized: true},
+		{ID: p.newId(), Name: "standard.medium", CPUs: 2, Memory: 4 << 30, Authorized: true},
+	}
+	p.Templates = []cloud.Template{
+		{ID: p.newId(), Name: "debian", Family: "debian", DefaultUser: "debian", Visibility: "public"},
+	}
+	return p
+}
+
+// AddInstance adds the instance as it is, e.g. in state "running", and
+// returns its ID, which is generated unless given.
+func (p *Provider) AddInstance(spec cloud.Instance) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if spec.ID == "" {
+		spec.ID = p.newId()
+	}
+	if spec.State == "" {
+		spec.State = "running"
+	}
+	spec.Zone = p.zone
+	p.instances[spec.ID] = &instance{Instance: spec}
+	return spec.ID
+}
+
+// SSHKey returns the public key registered under the name, or an empty string
+// if there is none.
+func (p *Provider) SSHKey(name string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sshKeys[name]
+}
+
+// Fail makes the calls of the method, given by its name, fail with the error
+// until Fail is called with a nil error.
+func (p *Provider) Fail(method string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		delete(p.failures, method)
+	} else {
+		p.failures[method] = err
+	}
+}
+
+// call simulates the latency of the method and returns the failure injected
+// for it, if any.
+func (p *Provider) call(ctx context.Context, method string) error {
+	if p.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.Latency):
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.failures[method]; err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	return nil
+}
+
+// newId returns a unique ID formatted like a UUID. It must be called with the
+// lock held, except during construction.
+func (p *Provider) newId() string {
+	p.nextId++
+	return fmt.Sprintf("00000000-0000-4000-8000-%012d", p.nextId)
+}
+
+// settle applies the operations that are due in the order they are done, so
+// that the latest of conflicting operations wins. It must be called with the
+// lock held.
+func (p *Provider) settle() {
+	now := time.Now()
+	var due []string
+	for id, op := range p.operations {
+		if !op.applied && !now.Before(op.done) {
+			due = append(due, id)
+		}
+	}
+	// IDs are increasing, which orders operations done at the same time
+	slices.SortFunc(due, func(a, b string) int {
+		if c := p.operations[a].done.Compare(p.operations[b].done); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	for _, id := range due {
+		p.operations[id].apply()
+		p.operations[id].applied = true
+	}
+}
+
+// schedule registers an operation that applies the change after Delay and
+// returns its ID. It must be called with the lock held.
+func (p *Provider) schedule(apply func()) string {
+	id := p.newId()
+	p.operations[id] = &operation{done: time.Now().Add(p.Delay), apply: apply}
+	p.settle()
+	return id
+}
+
+// lookup returns the instance, or an error wrapping cloud.ErrNotFound. It must
+// be called with the lock held.
+func (p *Provider) lookup(id string) (*instance, error) {
+	p.settle()
+	i, ok := p.instances[id]
+	if !ok {
+		return nil, fmt.Errorf("instance %s: %w", id, cloud.ErrNotFound)
+	}
+	return i, nil
+}
+
+// transition puts the instance into the transitional state, if it is in the
+// required state, and into the final state once the operation is done. It must
+// be called with the lock held.
+func (p *Provider) transition(id, required, transitional, final string) (string, error) {
+	i, err := p.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	if required != "" && i.State != required {
+		return "", fmt.Errorf("instance %s is %s, not %s", id, i.State, required)
+	}
+	i.State = transitional
+	return p.schedule(func() {
+		if i, ok := p.instances[id]; ok {
+			i.State = final
+		}
+	}), nil
+}
+
+func copyInstance(i *instance) *cloud.Instance {
+	c := i.Instance
+	c.Labels = maps.Clone(i.Labels)
+	c.SecurityGroups = slices.Clone(i.SecurityGroups)
+	c.SSHKeys = slices.Clone(i.SSHKeys)
+	return &c
+}
+
+func (p *Provider) GetInstances(ctx context.Context) ([]*cloud.Instance, error) {
+	if err := p.call(ctx, "GetInstances"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settle()
+	instances := make([]*cloud.Instance, 0, len(p.instances))
+	for _, i := range p.instances {
+		instances = append(instances, copyInstance(i))
+	}
+	slices.SortFunc(instances, func(a, b *cloud.Instance) int { return strings.Compare(a.Name, b.Name) })
+	return instances, nil
+}
+
+func (p *Provider) GetInstance(ctx context.Context, id string) (*cloud.Instance, error) {
+	if err := p.call(ctx, "GetInstance"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return copyInstance(i), nil
+}
+
+func (p *Provider) GetInstanceDetail(ctx context.Context, id string) (*cloud.Instance, error) {
+	if err := p.call(ctx, "GetInstanceDetail"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return copyInstance(i), nil
+}
+
+func (p *Provider) GetInstanceTypeName(ctx context.Context, id string) (string, error) {
+	if err := p.call(ctx, "GetInstanceTypeName"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	if i.InstanceType == nil {
+		return "", fmt.Errorf("instance %s lacks an instance type", id)
+	}
+	return i.InstanceType.Name, nil
+}
+
+func (p *Provider) CreateInstance(ctx context.Context, spec cloud.InstanceSpec) (*cloud.Instance, error) {
+	if err := p.call(ctx, "CreateInstance"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	index := slices.IndexFunc(p.InstanceTypes, func(t cloud.InstanceType) bool { return t.Name == spec.InstanceType })
+	if index < 0 {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("instance type %s: %w", spec.InstanceType, cloud.ErrNotFound)
+	}
+	for _, name := range spec.SSHKeys {
+		if _, ok := p.sshKeys[name]; !ok {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("SSH key %s: %w", name, cloud.ErrNotFound)
+		}
+	}
+	id := p.newId()
+	i := &instance{Instance: cloud.Instance{
+		ID:           id,
+		Name:         spec.Name,
+		Labels:       maps.Clone(spec.Labels),
+		IP:           fmt.Sprintf("192.0.2.%d", p.nextId%250+1),
+		State:        "starting",
+		Zone:         p.zone,
+		Created:      time.Now(),
+		InstanceType: &cloud.Reference{ID: p.InstanceTypes[index].ID, Name: spec.InstanceType},
+		Template:     &cloud.Reference{ID: spec.TemplateID},
+		DiskSize:     spec.DiskSize,
+		SSHKeys:      slices.Clone(spec.SSHKeys),
+	}}
+	for _, group := range spec.SecurityGroups {
+		i.SecurityGroups = append(i.SecurityGroups, cloud.Reference{ID: group, Name: group})
+	}
+	p.instances[id] = i
+	opId := p.schedule(func() { i.State = "running" })
+	p.mu.Unlock()
+	if err := p.WaitForOperation(ctx, opId, time.Hour); err != nil {
+		return nil, err
+	}
+	return p.GetInstance(ctx, id)
+}
+
+func (p *Provider) DeleteInstance(ctx context.Context, id string) error {
+	if err := p.call(ctx, "DeleteInstance"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	i, err := p.lookup(id)
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	i.State = "destroying"
+	opId := p.schedule(func() { delete(p.instances, id) })
+	p.mu.Unlock()
+	return p.WaitForOperation(ctx, opId, time.Hour)
+}
+
+func (p *Provider) UpdateInstanceLabels(ctx context.Context, id string, labels map[string]string) error {
+	if err := p.call(ctx, "UpdateInstanceLabels"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return err
+	}
+	i.Labels = maps.Clone(labels)
+	return nil
+}
+
+func (p *Provider) StartInstance(ctx context.Context, id string) (string, error) {
+	if err := p.call(ctx, "StartInstance"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transition(id, "stopped", "starting", "running")
+}
+
+func (p *Provider) StopInstance(ctx context.Context, id string) (string, error) {
+	if err := p.call(ctx, "StopInstance"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transition(id, "running", "stopping", "stopped")
+}
+
+func (p *Provider) RebootInstance(ctx context.Context, id string) (string, error) {
+	if err := p.call(ctx, "RebootInstance"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transition(id, "running", "starting", "running")
+}
+
+func (p *Provider) WaitForOperation(ctx context.Context, id string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		p.mu.Lock()
+		p.settle()
+		op, ok := p.operations[id]
+		p.mu.Unlock()
+		if !ok {
+			return fmt.Errorf("operation %s: %w", id, cloud.ErrNotFound)
+		}
+		if op.applied {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("wait for operation %s: %w", id, ctx.Err())
+		case <-time.After(time.Until(op.done)):
+		}
+	}
+}
+
+func (p *Provider) ForceStopInstance(ctx context.Context, id string, timeout time.Duration) error {
+	if err := p.call(ctx, "ForceStopInstance"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	opId, err := p.transition(id, "", "stopping", "stopped")
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return p.WaitForOperation(ctx, opId, timeout)
+}
+
+func (p *Provider) ResetInstance(ctx context.Context, id, templateId, userData string) (string, error) {
+	if err := p.call(ctx, "ResetInstance"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	i, err := p.lookup(id)
+	if err != nil {
+		p.mu.Unlock()
+		return "", err
+	}
+	if templateId == "" {
+		if i.Template == nil {
+			p.mu.Unlock()
+			return "", fmt.Errorf("instance %s has no template", id)
+		}
+		templateId = i.Template.ID
+	}
+	state := i.State
+	i.State = "starting"
+	opId := p.schedule(func() {
+		i.Template = &cloud.Reference{ID: templateId}
+		i.State = state
+	})
+	p.mu.Unlock()
+	return templateId, p.WaitForOperation(ctx, opId, time.Hour)
+}
+
+func (p *Provider) ScaleInstance(ctx context.Context, id, instanceType string) error {
+	if err := p.call(ctx, "ScaleInstance"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return err
+	}
+	if i.State != "stopped" {
+		return fmt.Errorf("instance %s is %s, not stopped", id, i.State)
+	}
+	index := slices.IndexFunc(p.InstanceTypes, func(t cloud.InstanceType) bool { return t.Name == instanceType })
+	if index < 0 {
+		return fmt.Errorf("instance type %s: %w", instanceType, cloud.ErrNotFound)
+	}
+	i.InstanceType = &cloud.Reference{ID: p.InstanceTypes[index].ID, Name: instanceType}
+	return nil
+}
+
+func (p *Provider) ResetInstancePassword(ctx context.Context, id string) error {
+	if err := p.call(ctx, "ResetInstancePassword"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return err
+	}
+	i.password = "password-" + p.newId()[24:]
+	return nil
+}
+
+func (p *Provider) RevealInstancePassword(ctx context.Context, id string) (string, error) {
+	if err := p.call(ctx, "RevealInstancePassword"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	return i.password, nil
+}
+
+func (p *Provider) GetConsoleURL(ctx context.Context, id string) (*cloud.ConsoleURL, error) {
+	if err := p.call(ctx, "GetConsoleURL"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.lookup(id); err != nil {
+		return nil, err
+	}
+	return &cloud.ConsoleURL{
+		URL:     fmt.Sprintf("wss://console.invalid/%s", id),
+		Expires: time.Now().Add(time.Minute),
+	}, nil
+}
+
+func (p *Provider) CreateSnapshot(ctx context.Context, id string) (string, error) {
+	if err := p.call(ctx, "CreateSnapshot"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	i, err := p.lookup(id)
+	if err != nil {
+		p.mu.Unlock()
+		return "", err
+	}
+	snapshot := &cloud.Snapshot{
+		ID:         p.newId(),
+		Name:       i.Name,
+		InstanceID: id,
+		Created:    time.Now(),
+		Size:       i.DiskSize,
+		State:      "snapshotting",
+	}
+	p.snapshots[snapshot.ID] = snapshot
+	opId := p.schedule(func() { snapshot.State = "ready" })
+	p.mu.Unlock()
+	return snapshot.ID, p.WaitForOperation(ctx, opId, time.Hour)
+}
+
+func (p *Provider) GetSnapshots(ctx context.Context, instanceId string) ([]*cloud.Snapshot, error) {
+	if err := p.call(ctx, "GetSnapshots"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settle()
+	snapshots := make([]*cloud.Snapshot, 0)
+	for _, snapshot := range p.snapshots {
+		if snapshot.InstanceID == instanceId {
+			c := *snapshot
+			snapshots = append(snapshots, &c)
+		}
+	}
+	slices.SortFunc(snapshots, func(a, b *cloud.Snapshot) int { return a.Created.Compare(b.Created) })
+	return snapshots, nil
+}
+
+func (p *Provider) GetSnapshot(ctx context.Context, id string) (*cloud.Snapshot, error) {
+	if err := p.call(ctx, "GetSnapshot"); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.settle()
+	snapshot, ok := p.snapshots[id]
+	if !ok {
+		return nil, fmt.Errorf("snapshot %s: %w", id, cloud.ErrNotFound)
+	}
+	c := *snapshot
+	return &c, nil
+}
+
+func (p *Provider) RevertToSnapshot(ctx context.Context, instanceId, snapshotId string) error {
+	if err := p.call(ctx, "RevertToSnapshot"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(instanceId)
+	if err != nil {
+		return err
+	}
+	snapshot, ok := p.snapshots[snapshotId]
+	if !ok || snapshot.InstanceID != instanceId {
+		return fmt.Errorf("snapshot %s of instance %s: %w", snapshotId, instanceId, cloud.ErrNotFound)
+	}
+	if i.State != "stopped" {
+		return fmt.Errorf("instance %s is %s, not stopped", instanceId, i.State)
+	}
+	return nil
+}
+
+func (p *Provider) DeleteSnapshot(ctx context.Context, id string) error {
+	if err := p.call(ctx, "DeleteSnapshot"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.snapshots[id]; !ok {
+		return fmt.Errorf("snapshot %s: %w", id, cloud.ErrNotFound)
+	}
+	delete(p.snapshots, id)
+	return nil
+}
+
+func (p *Provider) EnsureInstanceSecurityGroup(ctx context.Context, instanceId string) (string, error) {
+	if err := p.call(ctx, "EnsureInstanceSecurityGroup"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i, err := p.lookup(instanceId)
+	if err != nil {
+		return "", err
+	}
+	for _, group := range p.groups {
+		if group.instanceId == instanceId {
+			return group.id, nil
+		}
+	}
+	group := &securityGroup{id: p.newId(), instanceId: instanceId, rules: make(map[string]cloud.IngressRule)}
+	p.groups[group.id] = group
+	i.SecurityGroups = append(i.SecurityGroups, cloud.Reference{ID: group.id, Name: "cloud-castle-" + instanceId})
+	return group.id, nil
+}
+
+func (p *Provider) AddIngressRule(ctx context.Context, groupId string, rule cloud.IngressRule) (string, error) {
+	if err := p.call(ctx, "AddIngressRule"); err != nil {
+		return "", err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	group, ok := p.groups[groupId]
+	if !ok {
+		return "", fmt.Errorf("security group %s: %w", groupId, cloud.ErrNotFound)
+	}
+	ruleId := p.newId()
+	group.rules[ruleId] = rule
+	return ruleId, nil
+}
+
+func (p *Provider) DeleteSecurityGroupRule(ctx context.Context, groupId, ruleId string) error {
+	if err := p.call(ctx, "DeleteSecurityGroupRule"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	group, ok := p.groups[groupId]
+	if !ok {
+		return fmt.Errorf("security group %s: %w", groupId, cloud.ErrNotFound)
+	}
+	if _, ok := group.rules[ruleId]; !ok {
+		return fmt.Errorf("rule %s: %w", ruleId, cloud.ErrNotFound)
+	}
+	delete(group.rules, ruleId)
+	return nil
+}
+
+func (p *Provider) DeleteInstanceSecurityGroup(ctx context.Context, instanceId string) error {
+	if err := p.call(ctx, "DeleteInstanceSecurityGroup"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, group := range p.groups {
+		if group.instanceId == instanceId {
+			delete(p.groups, id)
+		}
+	}
+	return nil
+}
+
+// EnsureSSHKey registers the public key in this zone only, unlike real
+// clouds, which share keys between zones.
+func (p *Provider) EnsureSSHKey(ctx context.Context, name, publicKey string) error {
+	if err := p.call(ctx, "EnsureSSHKey"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.sshKeys[name]; !ok {
+		p.sshKeys[name] = publicKey
+	}
+	return nil
+}
+
+func (p *Provider) DeleteSSHKey(ctx context.Context, name string) error {
+	if err := p.call(ctx, "DeleteSSHKey"); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.sshKeys[name]; !ok {
+		return fmt.Errorf("SSH key %s: %w", name, cloud.ErrNotFound)
+	}
+	delete(p.sshKeys, name)
+	return nil
+}
+
+func (p *Provider) ListZones(ctx context.Context) ([]string, error) {
+	if err := p.call(ctx, "ListZones"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(p.Zones), nil
+}
+
+func (p *Provider) ListInstanceTypes(ctx context.Context) ([]cloud.InstanceType, error) {
+	if err := p.call(ctx, "ListInstanceTypes"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(p.InstanceTypes), nil
+}
+
+func (p *Provider) ListTemplates(ctx context.Context) ([]cloud.Template, error) {
+	if err := p.call(ctx, "ListTemplates"); err != nil {
+		return nil, err
+	}
+	return slices.Clone(p.Templates), nil
+}
@@ -0,0 +1,112 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+func TestTransitions(t *testing.T) {
+	ctx := context.Background()
+	p := New("ch-gva-2")
+	p.Delay = 20 * time.Millisecond
+	id := p.AddInstance(cloud.Instance{Name: "alice-linux", State: "stopped"})
+
+	opId, err := p.StartInstance(ctx, id)
+	if err != nil {
+		t.Fatalf("start instance: %v", err)
+	}
+	if instance, _ := p.GetInstance(ctx, id); instance.State != "starting" {
+		t.Errorf("expected instance to be starting, was %s", instance.State)
+	}
+	if _, err := p.StartInstance(ctx, id); err == nil {
+		t.Errorf("expected starting instance not to be started again")
+	}
+	if err := p.WaitForOperation(ctx, opId, time.Second); err != nil {
+		t.Fatalf("wait for start: %v", err)
+	}
+	if instance, _ := p.GetInstance(ctx, id); instance.State != "running" {
+		t.Errorf("expected instance to be running, was %s", instance.State)
+	}
+
+	opId, err = p.StopInstance(ctx, id)
+	if err != nil {
+		t.Fatalf("stop instance: %v", err)
+	}
+	if err := p.WaitForOperation(ctx, opId, time.Millisecond); err == nil {
+		t.Errorf("expected wait to time out before the instance stopped")
+	}
+	time.Sleep(p.Delay)
+	if instance, _ := p.GetInstance(ctx, id); instance.State != "stopped" {
+		t.Errorf("expected instance to be stopped, was %s", instance.State)
+	}
+
+	if err := p.DeleteInstance(ctx, id); err != nil {
+		t.Fatalf("delete instance: %v", err)
+	}
+	if _, err := p.GetInstance(ctx, id); !errors.Is(err, cloud.ErrNotFound) {
+		t.Errorf("expected deleted instance not to be found, got %v", err)
+	}
+}
+
+func TestConflictingOperations(t *testing.T) {
+	ctx := context.Background()
+	p := New("ch-gva-2")
+	p.Delay = 5 * time.Millisecond
+	// both operations are due when the forced stop settles them, which used to
+	// apply them in random order
+	for range 20 {
+		id := p.AddInstance(cloud.Instance{Name: "alice-linux", State: "stopped"})
+		if _, err := p.StartInstance(ctx, id); err != nil {
+			t.Fatalf("start instance: %v", err)
+		}
+		if err := p.ForceStopInstance(ctx, id, time.Second); err != nil {
+			t.Fatalf("force stop instance: %v", err)
+		}
+		if instance, _ := p.GetInstance(ctx, id); instance.State != "stopped" {
+			t.Fatalf("expected instance to be stopped, was %s", instance.State)
+		}
+	}
+}
+
+func TestFailures(t *testing.T) {
+	ctx := context.Background()
+	p := New("ch-gva-2")
+	id := p.AddInstance(cloud.Instance{Name: "alice-linux"})
+
+	p.Fail("RebootInstance", cloud.ErrUnavailable)
+	if _, err := p.RebootInstance(ctx, id); !errors.Is(err, cloud.ErrUnavailable) {
+		t.Errorf("expected injected failure, got %v", err)
+	}
+	if _, err := p.GetInstance(ctx, id); err != nil {
+		t.Errorf("expected other methods to succeed, got %v", err)
+	}
+	p.Fail("RebootInstance", nil)
+	if _, err := p.RebootInstance(ctx, id); err != nil {
+		t.Errorf("expected reboot to succeed once the failure is removed, got %v", err)
+	}
+
+	p.Latency = time.Second
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.GetInstances(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected call to be cancelled during latency, got %v", err)
+	}
+}
+
+func TestCloud(t *testing.T) {
+	c := NewCloud()
+	id := c.Zone("ch-gva-2").AddInstance(cloud.Instance{Name: "alice-linux"})
+	access := cloud.NewAccess(c.NewProvider, "alice", cloud.Credentials{Zone: "ch-gva-2", Key: "EXO1"})
+	instance, err := access.GetInstance(context.Background(), id)
+	if err != nil || instance.Zone != "ch-gva-2" {
+		t.Errorf("expected instance in zone ch-gva-2, got %v (%v)", instance, err)
+	}
+	other := cloud.NewAccess(c.NewProvider, "alice", cloud.Credentials{Zone: "de-fra-1", Key: "EXO1"})
+	if _, err := other.GetInstance(context.Background(), id); !errors.Is(err, cloud.ErrNotFound) {
+		t.Errorf("expected instance not to be found in another zone, got %v", err)
+	}
+}
@@ -5,32 +5,69 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/exoscale"
 	"github.com/composed-ch/cloud-castle-backend/internal/config"
+	"github.com/composed-ch/cloud-castle-backend/openstack"
 )
 
 func main() {
 	username := flag.String("username", "", "the unique name of the user")
-	zone := flag.String("zone", "", "the zone for which the key is used")
-	apiKey := flag.String("key", "", "API key")
-	apiSecret := flag.String("secret", "", "API secret")
+	provider := flag.String("provider", "exoscale", "the kind of cloud: exoscale or openstack")
+	endpoint := flag.String("endpoint", "", "the Keystone URL (OpenStack only)")
+	zone := flag.String("zone", "", "the zone (OpenStack: region) for which the key is used")
+	apiKey := flag.String("key", "", "API key (OpenStack: application credential ID)")
+	apiSecret := flag.String("secret", "", "API secret (OpenStack: application credential secret)")
 	tenant := flag.String("tenant", "", "Exoscale tenant (account name)")
 	flag.Parse()
 
+	if *provider == "openstack" && *endpoint == "" {
+		fmt.Fprintln(os.Stderr, "OpenStack keys require an endpoint")
+		os.Exit(1)
+	}
+	providers := cloud.Providers{"exoscale": exoscale.NewProvider, "openstack": openstack.NewProvider}
+	api, err := providers.Access(*username, cloud.Credentials{
+		Provider: *provider,
+		Zone:     *zone,
+		Endpoint: *endpoint,
+		Key:      *apiKey,
+		Secret:   *apiSecret,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	zones, err := api.ListZones(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listing zones with the given key: %v\n", err)
+		os.Exit(1)
+	}
+	if !slices.Contains(zones, *zone) {
+		fmt.Fprintf(os.Stderr, "unknown zone '%s', must be one of: %s\n", *zone, strings.Join(zones, ", "))
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
 	conn := config.MustGetConnection()
 	defer conn.Close(ctx)
 
 	var accountId uint
-	err := conn.QueryRow(ctx, "select id from account where name = $1", username).Scan(&accountId)
+	err = conn.QueryRow(ctx, "select id from account where name = $1", username).Scan(&accountId)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "selecting account id by username: %v\n", err)
 		os.Exit(1)
 	}
 
+	var nullableEndpoint *string
+	if *endpoint != "" {
+		nullableEndpoint = endpoint
+	}
 	_, err = conn.Exec(ctx,
-		"insert into api_key (zone, api_key, api_secret, tenant) values ($1, $2, $3, $4)",
-		zone, apiKey, apiSecret, tenant)
+		"insert into api_key (zone, api_key, api_secret, tenant, provider, endpoint) values ($1, $2, $3, $4, $5, $6)",
+		zone, apiKey, apiSecret, tenant, provider, nullableEndpoint)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "inserting api key: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/config"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+func main() {
+	instance := flag.String("instance", "", "the ID of the Exoscale instance")
+	zone := flag.String("zone", "", "the zone the instance is running in")
+	tenant := flag.String("tenant", "", "Exoscale tenant (account name)")
+	username := flag.String("username", "", "the account to assign the instance to")
+	group := flag.String("group", "", "the group to assign the instance to")
+	permission := flag.String("permission", "owner", "permission level: 'view', 'operate' or 'owner'")
+	flag.Parse()
+
+	if *instance == "" || *zone == "" || *tenant == "" {
+		fmt.Fprintln(os.Stderr, "instance, zone and tenant are required")
+		os.Exit(1)
+	}
+	if *username != "" && *group != "" || *username == "" && *group == "" {
+		fmt.Fprintln(os.Stderr, "define either username or group")
+		os.Exit(1)
+	}
+	if !db.Permission(*permission).Valid() {
+		fmt.Fprintf(os.Stderr, "invalid permission '%s'\n", *permission)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	pool := config.MustGetConnectionPool()
+	defer pool.Close()
+
+	if *username != "" {
+		account, err := db.LoadAccountByName(ctx, pool, *username)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "load account by name '%s': %v\n", *username, err)
+			os.Exit(1)
+		}
+		err = db.AssignInstanceToAccount(ctx, pool, *instance, *zone, *tenant, account.Id, db.Permission(*permission))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		g, err := db.LoadGroupByName(ctx, pool, *group, *tenant)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		err = db.AssignInstanceToGroup(ctx, pool, *instance, *zone, *tenant, g.Id, db.Permission(*permission))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/composed-ch/cloud-castle-backend/internal/auth"
 	"github.com/composed-ch/cloud-castle-backend/internal/config"
@@ -11,22 +16,96 @@ import (
 	"github.com/composed-ch/cloud-castle-backend/internal/middleware"
 )
 
+// shutdownTimeout is how long the requests in progress may take to finish once
+// the server is asked to shut down.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	cfg := config.MustReadConfig()
-	state, err := endpoints.NewStateful(&cfg)
+	state, err := endpoints.NewStateful(ctx, &cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "initializing state: %v\n", err)
 		os.Exit(1)
 	}
 
+	go state.RunDeletions(ctx, time.Minute)
+	go state.RunIdempotencyKeyPurge(ctx, time.Hour)
+	go state.ResumeOperations(ctx, time.Minute)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /canary", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) })
 	mux.HandleFunc("POST /login", state.Login)
+	mux.HandleFunc("POST /login/link", state.RequestLoginLink)
+	mux.HandleFunc("POST /login/link/redeem", state.RedeemLoginLink)
 	mux.HandleFunc("GET /instances", auth.Authenticated(state.GetInstances))
+	mux.HandleFunc("POST /instances", auth.Authenticated(state.Idempotent(state.CreateInstance)))
+	mux.HandleFunc("GET /catalog/zones", auth.Authenticated(state.GetZones))
+	mux.HandleFunc("GET /catalog/instance-types", auth.Authenticated(state.GetCatalogInstanceTypes))
+	mux.HandleFunc("GET /catalog/templates", auth.Authenticated(state.GetTemplates))
+	mux.HandleFunc("GET /instances/events", auth.Authenticated(state.StreamInstanceStates))
+	mux.HandleFunc("GET /metrics", auth.Authenticated(state.GetMetrics))
+	mux.HandleFunc("GET /operations/{id}", auth.Authenticated(state.GetOperation))
+	mux.HandleFunc("GET /offerings", auth.Authenticated(state.GetOfferings))
+	mux.HandleFunc("POST /groups/{id}/offerings", auth.Authenticated(state.Idempotent(state.CreateOffering)))
+	mux.HandleFunc("POST /groups/{id}/actions", auth.Authenticated(state.Idempotent(state.BulkAction)))
+	mux.HandleFunc("POST /groups/{id}/user-data/preview", auth.Authenticated(state.PreviewUserData))
+	mux.HandleFunc("GET /groups/{id}/instance-types", auth.Authenticated(state.GetInstanceTypes))
+	mux.HandleFunc("POST /groups/{id}/instance-types", auth.Authenticated(state.Idempotent(state.AllowInstanceType)))
+	mux.HandleFunc("DELETE /groups/{id}/instance-types/{type}", auth.Authenticated(state.Idempotent(state.DisallowInstanceType)))
+	mux.HandleFunc("GET /resizes", auth.Authenticated(state.GetPendingResizes))
+	mux.HandleFunc("POST /resizes/{id}/approve", auth.Authenticated(state.Idempotent(state.ApproveResize)))
+	mux.HandleFunc("POST /resizes/{id}/deny", auth.Authenticated(state.Idempotent(state.DenyResize)))
+	mux.HandleFunc("GET /rules", auth.Authenticated(state.GetPendingIngressRules))
+	mux.HandleFunc("POST /rules/{id}/approve", auth.Authenticated(state.Idempotent(state.ApproveIngressRule)))
+	mux.HandleFunc("POST /rules/{id}/deny", auth.Authenticated(state.Idempotent(state.DenyIngressRule)))
+	mux.HandleFunc("DELETE /offerings/{id}", auth.Authenticated(state.Idempotent(state.DeleteOffering)))
+	mux.HandleFunc("GET /instance/{id}", auth.Authenticated(state.GetInstance))
 	mux.HandleFunc("GET /instance/{id}/state", auth.Authenticated(state.GetInstanceState))
-	mux.HandleFunc("GET /instance/{id}/start", auth.Authenticated(state.StartInstance))
-	mux.HandleFunc("GET /instance/{id}/stop", auth.Authenticated(state.StopInstance))
+	mux.HandleFunc("POST /instance/{id}/start", auth.Authenticated(state.Idempotent(state.StartInstance)))
+	mux.HandleFunc("POST /instance/{id}/stop", auth.Authenticated(state.Idempotent(state.StopInstance)))
+	mux.HandleFunc("GET /instance/{id}/console", auth.Authenticated(state.GetConsoleURL))
+	mux.HandleFunc("POST /instance/{id}/password/reset", auth.Authenticated(state.Idempotent(state.ResetInstancePassword)))
+	mux.HandleFunc("POST /instance/{id}/password/reveal", auth.Authenticated(state.RevealInstancePassword))
+	mux.HandleFunc("GET /instance/{id}/rules", auth.Authenticated(state.GetIngressRules))
+	mux.HandleFunc("POST /instance/{id}/rules", auth.Authenticated(state.Idempotent(state.RequestIngressRule)))
+	mux.HandleFunc("DELETE /instance/{id}/rules/{rule}", auth.Authenticated(state.Idempotent(state.RemoveIngressRule)))
+	mux.HandleFunc("POST /instance/{id}/resize", auth.Authenticated(state.Idempotent(state.ResizeInstance)))
+	mux.HandleFunc("POST /instance/{id}/reboot", auth.Authenticated(state.Idempotent(state.RebootInstance)))
+	mux.HandleFunc("POST /instance/{id}/force-stop", auth.Authenticated(state.Idempotent(state.ForceStopInstance)))
+	mux.HandleFunc("POST /instance/{id}/reset/confirmation", auth.Authenticated(state.RequestResetConfirmation))
+	mux.HandleFunc("POST /instance/{id}/reset", auth.Authenticated(state.Idempotent(state.ResetInstance)))
+	mux.HandleFunc("GET /instance/{id}/snapshots", auth.Authenticated(state.GetSnapshots))
+	mux.HandleFunc("POST /instance/{id}/snapshots", auth.Authenticated(state.Idempotent(state.CreateSnapshot)))
+	mux.HandleFunc("POST /instance/{id}/snapshots/{snapshot}/revert", auth.Authenticated(state.Idempotent(state.RevertSnapshot)))
+	mux.HandleFunc("DELETE /instance/{id}/snapshots/{snapshot}", auth.Authenticated(state.Idempotent(state.DeleteSnapshot)))
+	mux.HandleFunc("DELETE /instance/{id}", auth.Authenticated(state.Idempotent(state.DeleteInstance)))
+	mux.HandleFunc("POST /instance/{id}/restore", auth.Authenticated(state.Idempotent(state.RestoreInstance)))
+	mux.HandleFunc("GET /ssh-keys", auth.Authenticated(state.GetSSHKeys))
+	mux.HandleFunc("POST /ssh-keys", auth.Authenticated(state.Idempotent(state.AddSSHKey)))
+	mux.HandleFunc("POST /ssh-keys/generate", auth.Authenticated(state.Idempotent(state.GenerateSSHKey)))
+	mux.HandleFunc("DELETE /ssh-keys/{id}", auth.Authenticated(state.Idempotent(state.DeleteSSHKey)))
 	mux.HandleFunc("POST /password/reset", state.ResetPassword)
 	mux.HandleFunc("POST /password/new", state.NewPassword)
-	http.ListenAndServe("127.0.0.1:8080", middleware.AllowCORS(mux))
+	server := &http.Server{Addr: "127.0.0.1:8080", Handler: middleware.AllowCORS(mux)}
+	shutdown := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		// the requests in progress are finished, the operations tracked are
+		// released for other replicas to resume
+		timeout, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(timeout); err != nil {
+			fmt.Fprintf(os.Stderr, "shut down server: %v\n", err)
+			server.Close()
+		}
+		close(shutdown)
+	}()
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		os.Exit(1)
+	}
+	<-shutdown
+	state.Wait()
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/config"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/composed-ch/cloud-castle-backend/internal/endpoints"
+)
+
+// import-owner-labels assigns every instance carrying an 'owner' label to the
+// account of the same name within the tenant, granting it owner permission.
+func main() {
+	user := flag.String("user", "", "the user whose API key is used (determines the tenant)")
+	dryRun := flag.Bool("dry-run", false, "only print the assignments that would be created")
+	flag.Parse()
+
+	cfg := config.MustReadConfig()
+	state, err := endpoints.NewStateful(context.Background(), &cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "initializing state: %v\n", err)
+		os.Exit(1)
+	}
+	defer state.Pool.Close()
+
+	accesses, err := state.GetAPIAccesses(*user)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get API access for user '%s': %v\n", *user, err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	account, err := db.LoadAccountByName(ctx, state.Pool, *user)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account by name '%s': %v\n", *user, err)
+		os.Exit(1)
+	}
+
+	for _, api := range accesses {
+		instances, err := api.GetInstances(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get instances in zone %s: %v\n", api.Zone, err)
+			continue
+		}
+		for _, instance := range instances {
+			importOwnerLabel(ctx, state, api, account, instance, *dryRun)
+		}
+	}
+}
+
+func importOwnerLabel(ctx context.Context, state *endpoints.Stateful, api *cloud.Access, account *db.Account, instance *cloud.Instance, dryRun bool) {
+	owner, ok := instance.Labels["owner"]
+	if !ok {
+		return
+	}
+	ownerAccount, err := db.LoadAccountByName(ctx, state.Pool, owner)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "instance %s (%s): no account for owner '%s': %v\n", instance.ID, instance.Name, owner, err)
+		return
+	}
+	if ownerAccount.Tenant != account.Tenant {
+		fmt.Fprintf(os.Stderr, "instance %s (%s): owner '%s' belongs to tenant '%s'\n", instance.ID, instance.Name, owner, ownerAccount.Tenant)
+		return
+	}
+	if dryRun {
+		fmt.Printf("would assign instance %s (%s) in zone %s to %s\n", instance.ID, instance.Name, api.Zone, owner)
+		return
+	}
+	err = db.AssignInstanceToAccount(ctx, state.Pool, instance.ID, api.Zone, account.Tenant, ownerAccount.Id, db.PERMISSION_OWNER)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "instance %s (%s): %v\n", instance.ID, instance.Name, err)
+		return
+	}
+	fmt.Printf("assigned instance %s (%s) in zone %s to %s\n", instance.ID, instance.Name, api.Zone, owner)
+}
@@ -23,14 +23,14 @@ func main() {
 	}
 
 	cfg := config.MustReadConfig()
-	state, err := endpoints.NewStateful(&cfg)
+	state, err := endpoints.NewStateful(context.Background(), &cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "initializing state: %v\n", err)
 		os.Exit(1)
 	}
 	defer state.Pool.Close()
 
-	api, err := state.GetAPIAccess(*user)
+	accesses, err := state.GetAPIAccesses(*user)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "get API access for user '%s': %v\n", *user, err)
 		os.Exit(1)
@@ -43,31 +43,26 @@ func main() {
 		os.Exit(1)
 	}
 
-	instances, err := api.GetInstances()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "get instances: %v\n", err)
-		os.Exit(1)
-	}
-
-	shutdownInstanceIds := make([]string, 0)
-	for _, instance := range instances {
-		if instance.State != "running" {
+	for _, api := range accesses {
+		instances, err := api.GetInstances(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get instances in zone %s: %v\n", api.Zone, err)
 			continue
 		}
-		if *label != "" {
-			if instanceValue, ok := instance.Labels[*label]; ok && instanceValue == *value {
-				shutdownInstanceIds = append(shutdownInstanceIds, instance.ID)
+		for _, instance := range instances {
+			if instance.State != "running" {
+				continue
+			}
+			if *label != "" {
+				if instanceValue, ok := instance.Labels[*label]; !ok || instanceValue != *value {
+					continue
+				}
+			}
+			if _, err := api.StopInstance(ctx, instance.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "shutdown instance %s: %v\n", instance.ID, err)
+			} else {
+				db.LogEvent(ctx, state.Pool, db.INSTANCE_STOP, accountId, "instance", instance.ID)
 			}
-		} else {
-			shutdownInstanceIds = append(shutdownInstanceIds, instance.ID)
-		}
-	}
-
-	for _, id := range shutdownInstanceIds {
-		if err := api.StopInstance(id); err != nil {
-			fmt.Fprintf(os.Stderr, "shutdown instance %s: %v\n", id, err)
-		} else {
-			db.LogEvent(ctx, state.Pool, db.INSTANCE_STOP, accountId, "instance", id)
 		}
 	}
 }
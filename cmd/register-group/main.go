@@ -10,6 +10,8 @@ import (
 	"github.com/composed-ch/cloud-castle-backend/internal/auth"
 	"github.com/composed-ch/cloud-castle-backend/internal/config"
 	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/composed-ch/cloud-castle-backend/internal/sshkey"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"go.yaml.in/yaml/v3"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -19,6 +21,8 @@ func main() {
 	password := flag.String("password", "", "initial password (random if left blank)")
 	role := flag.String("role", "student", "user role: 'student' (default) or 'teacher'")
 	tenant := flag.String("tenant", "", "Exoscale tenant (account name)")
+	maxInstances := flag.Int("max-instances", 0, "number of instances each student may create (unchanged if 0)")
+	maxSnapshots := flag.Int("max-snapshots", 0, "number of snapshots each student may keep (unchanged if 0)")
 	flag.Parse()
 
 	if *role != "teacher" && *role != "student" {
@@ -40,9 +44,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	if group.Name == "" {
+		fmt.Fprintf(os.Stderr, "group file lacks a name\n")
+		os.Exit(1)
+	}
+
+	groupId, err := db.EnsureGroup(ctx, pool, group.Name, *tenant)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create group: %v\n", err)
+		os.Exit(1)
+	}
+	if *maxInstances > 0 {
+		if err := db.SetGroupMaxInstances(ctx, pool, groupId, *maxInstances); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if *maxSnapshots > 0 {
+		if err := db.SetGroupMaxSnapshots(ctx, pool, groupId, *maxSnapshots); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	for _, user := range group.Users {
-		if _, err := db.LoadAccountByName(ctx, pool, user.Name); err == nil {
+		if account, err := db.LoadAccountByName(ctx, pool, user.Name); err == nil {
 			fmt.Fprintf(os.Stderr, "user with username '%s' already exists\n", user.Name)
+			if err := db.AddGroupMember(ctx, pool, groupId, account.Id); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			importSSHKey(ctx, pool, account.Id, user)
 			continue
 		}
 		var userPassword string
@@ -66,9 +97,45 @@ func main() {
 			continue
 		}
 		db.LogEvent(ctx, pool, db.ACCOUNT_CREATED, accountId, "name", user.Name)
+		if err := db.AddGroupMember(ctx, pool, groupId, accountId); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		importSSHKey(ctx, pool, accountId, user)
 	}
 }
 
+// importSSHKey adds the SSH key given for the user in the group file to the
+// account, unless the account has it already. The key is registered with the
+// cloud once the user creates an instance.
+func importSSHKey(ctx context.Context, pool *pgxpool.Pool, accountId int, user User) {
+	if user.SSHKey == "" {
+		return
+	}
+	publicKey, err := sshkey.Parse(user.SSHKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid SSH key of user %s, skipping: %v\n", user.Name, err)
+		return
+	}
+	key := db.SSHKey{
+		AccountId:   accountId,
+		Name:        importedSSHKeyName,
+		PublicKey:   publicKey.Authorized,
+		Type:        publicKey.Type,
+		Fingerprint: publicKey.Fingerprint,
+	}
+	inserted, err := db.InsertSSHKey(ctx, pool, &key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else if !inserted {
+		fmt.Fprintf(os.Stderr, "user %s has the SSH key or one named %s already\n", user.Name, importedSSHKeyName)
+	} else {
+		db.LogEvent(ctx, pool, db.SSH_KEY_ADDED, accountId, "fingerprint", key.Fingerprint)
+	}
+}
+
+// importedSSHKeyName is the name given to SSH keys imported from group files.
+const importedSSHKeyName = "group file"
+
 func readGroupFromFile(file string) (*Group, error) {
 	f, err := os.Open(file)
 	if err != nil {
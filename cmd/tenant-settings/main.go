@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/config"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+func main() {
+	tenant := flag.String("tenant", "", "Exoscale tenant (account name)")
+	loginLink := flag.Bool("login-link", false, "allow passwordless login by email link")
+	examMode := flag.Bool("exam-mode", false, "block actions that could be used to cheat during an exam")
+	flag.Parse()
+
+	if *tenant == "" {
+		fmt.Fprintf(os.Stderr, "missing tenant\n")
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	pool := config.MustGetConnectionPool()
+	defer pool.Close()
+
+	settings, err := db.LoadTenantSettings(ctx, pool, *tenant)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load settings: %v\n", err)
+		os.Exit(1)
+	}
+	// only change the settings that were given explicitly
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "login-link":
+			settings.LoginLinkEnabled = *loginLink
+		case "exam-mode":
+			settings.ExamMode = *examMode
+		}
+	})
+	if err := db.SaveTenantSettings(ctx, pool, settings); err != nil {
+		fmt.Fprintf(os.Stderr, "save settings: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,158 @@
+package exoscale
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	v3 "github.com/exoscale/egoscale/v3"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultInstanceCacheTTL is how long instance listings are reused unless
+// configured otherwise using SetInstanceCacheTTL.
+const DefaultInstanceCacheTTL = 5 * time.Second
+
+// clientKey identifies the credentials and zone a client talks to.
+type clientKey struct {
+	zone   string
+	key    string
+	secret string
+}
+
+// clients holds one long-lived client per credentials and zone.
+var clients sync.Map
+
+// instances caches the instance listings of every client.
+var instances = newInstanceCache(DefaultInstanceCacheTTL)
+
+// SetInstanceCacheTTL changes how long instance listings are reused. A TTL of
+// zero disables the cache, but concurrent listings are still coalesced.
+func SetInstanceCacheTTL(ttl time.Duration) {
+	instances.mu.Lock()
+	defer instances.mu.Unlock()
+	instances.ttl = ttl
+}
+
+// CacheStats counts how instance listings were served.
+type CacheStats struct {
+	// Hits were served from the cache.
+	Hits int64 `json:"hits"`
+	// Misses were listed from Exoscale.
+	Misses int64 `json:"misses"`
+	// Coalesced waited for a listing requested concurrently by someone else.
+	Coalesced int64 `json:"coalesced"`
+	// HitRate is the share of listings that did not call Exoscale.
+	HitRate float64 `json:"hit_rate"`
+}
+
+func InstanceCacheStats() CacheStats {
+	stats := CacheStats{
+		Hits:      instances.hits.Load(),
+		Misses:    instances.misses.Load(),
+		Coalesced: instances.coalesced.Load(),
+	}
+	if total := stats.Hits + stats.Misses + stats.Coalesced; total > 0 {
+		stats.HitRate = float64(stats.Hits+stats.Coalesced) / float64(total)
+	}
+	return stats
+}
+
+func (a *APIAccess) clientKey() clientKey {
+	return clientKey{zone: a.Zone, key: a.Key, secret: a.Secret}
+}
+
+// invalidateInstances drops the cached listing after a change to an instance.
+func (a *APIAccess) invalidateInstances() {
+	instances.invalidate(a.clientKey())
+}
+
+// newClient creates a client that retries reads only, rather than using the
+// retrying HTTP client of egoscale, which repeats any failed request.
+func (a *APIAccess) newClient() (*v3.Client, error) {
+	return v3.NewClient(a.Creds,
+		v3.ClientOptWithEndpoint(v3.Endpoint(fmt.Sprintf("https://api-%s.exoscale.com/v2", a.Zone))),
+		v3.ClientOptWithHTTPClient(newHTTPClient()))
+}
+
+type instanceEntry struct {
+	instances []*cloud.Instance
+	expires   time.Time
+}
+
+// instanceCache keeps instance listings for a short time and lets concurrent
+// requests for the same listing share a single call to Exoscale.
+type instanceCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[clientKey]instanceEntry
+	// generations counts the invalidations per key, so that listings started
+	// before an invalidation are not cached.
+	generations map[clientKey]int
+	group       singleflight.Group
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	coalesced atomic.Int64
+}
+
+func newInstanceCache(ttl time.Duration) *instanceCache {
+	return &instanceCache{
+		ttl:         ttl,
+		entries:     make(map[clientKey]instanceEntry),
+		generations: make(map[clientKey]int),
+	}
+}
+
+// get returns a copy of the cached listing, or loads it. The load is shared by
+// concurrent callers and therefore not canceled with the context, but the
+// caller stops waiting for it when the context is done.
+func (c *instanceCache) get(ctx context.Context, key clientKey, load func(context.Context) ([]*cloud.Instance, error)) ([]*cloud.Instance, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	generation := c.generations[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		c.hits.Add(1)
+		return slices.Clone(entry.instances), nil
+	}
+	var leader atomic.Bool
+	results := c.group.DoChan(fmt.Sprintf("%s/%s/%d", key.zone, key.key, generation), func() (any, error) {
+		leader.Store(true)
+		c.misses.Add(1)
+		listed, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		if c.generations[key] == generation && c.ttl > 0 {
+			c.entries[key] = instanceEntry{instances: listed, expires: time.Now().Add(c.ttl)}
+		}
+		c.mu.Unlock()
+		return listed, nil
+	})
+	var result singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result = <-results:
+	}
+	if result.Shared && !leader.Load() {
+		c.coalesced.Add(1)
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return slices.Clone(result.Val.([]*cloud.Instance)), nil
+}
+
+func (c *instanceCache) invalidate(key clientKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	c.generations[key]++
+}
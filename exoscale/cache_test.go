@@ -0,0 +1,94 @@
+package exoscale
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+func TestInstanceCache(t *testing.T) {
+	cache := newInstanceCache(time.Hour)
+	key := clientKey{zone: "ch-gva-2", key: "EXO123"}
+	loads := 0
+	load := func(context.Context) ([]*cloud.Instance, error) {
+		loads++
+		return []*cloud.Instance{{ID: "a"}}, nil
+	}
+	for range 3 {
+		listed, err := cache.get(context.Background(), key, load)
+		if err != nil || len(listed) != 1 {
+			t.Fatalf("expected one instance, got %v (%v)", listed, err)
+		}
+		listed[0] = &cloud.Instance{ID: "modified"}
+	}
+	if loads != 1 {
+		t.Errorf("expected one load within the time to live, got %d", loads)
+	}
+	if listed, _ := cache.get(context.Background(), key, load); listed[0].ID != "a" {
+		t.Errorf("expected callers to get copies of the cached listing, got %s", listed[0].ID)
+	}
+
+	cache.invalidate(key)
+	cache.get(context.Background(), key, load)
+	if loads != 2 {
+		t.Errorf("expected invalidated listing to be loaded again, got %d loads", loads)
+	}
+	if hits := cache.hits.Load(); hits != 3 {
+		t.Errorf("expected 3 hits, got %d", hits)
+	}
+}
+
+func TestInstanceCacheCoalescing(t *testing.T) {
+	cache := newInstanceCache(0)
+	key := clientKey{zone: "ch-gva-2", key: "EXO123"}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	loads := 0
+	load := func(context.Context) ([]*cloud.Instance, error) {
+		mu.Lock()
+		loads++
+		mu.Unlock()
+		<-release
+		return []*cloud.Instance{{ID: "a"}}, nil
+	}
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.get(context.Background(), key, load)
+		}()
+	}
+	// give the callers time to join the first load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if loads != 1 {
+		t.Errorf("expected concurrent listings to share one load, got %d", loads)
+	}
+	if coalesced := cache.coalesced.Load(); coalesced != 9 {
+		t.Errorf("expected 9 coalesced listings, got %d", coalesced)
+	}
+}
+
+func TestInstanceCacheInvalidationDuringLoad(t *testing.T) {
+	cache := newInstanceCache(time.Hour)
+	key := clientKey{zone: "ch-gva-2", key: "EXO123"}
+	loads := 0
+	stale := func(context.Context) ([]*cloud.Instance, error) {
+		loads++
+		cache.invalidate(key)
+		return []*cloud.Instance{{ID: "a", State: "running"}}, nil
+	}
+	cache.get(context.Background(), key, stale)
+	cache.get(context.Background(), key, func(context.Context) ([]*cloud.Instance, error) {
+		loads++
+		return []*cloud.Instance{{ID: "a", State: "stopped"}}, nil
+	})
+	if loads != 2 {
+		t.Errorf("expected listing started before invalidation not to be cached, got %d loads", loads)
+	}
+}
@@ -0,0 +1,90 @@
+package exoscale
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	v3 "github.com/exoscale/egoscale/v3"
+)
+
+func (a *APIAccess) ListZones(ctx context.Context) ([]string, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	resp, err := client.ListZones(ctx)
+	if err != nil {
+		return nil, errorf("list zones: %w", err)
+	}
+	zones := make([]string, 0, len(resp.Zones))
+	for _, zone := range resp.Zones {
+		zones = append(zones, string(zone.Name))
+	}
+	slices.Sort(zones)
+	return zones, nil
+}
+
+// ListInstanceTypes returns the instance types offered in the zone.
+func (a *APIAccess) ListInstanceTypes(ctx context.Context) ([]cloud.InstanceType, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	resp, err := client.ListInstanceTypes(ctx)
+	if err != nil {
+		return nil, errorf("list instance types: %w", err)
+	}
+	types := make([]cloud.InstanceType, 0, len(resp.InstanceTypes))
+	for _, t := range resp.InstanceTypes {
+		if len(t.Zones) > 0 && !slices.Contains(t.Zones, v3.ZoneName(a.Zone)) {
+			continue
+		}
+		types = append(types, cloud.InstanceType{
+			ID:         t.ID.String(),
+			Name:       fmt.Sprintf("%s.%s", t.Family, t.Size),
+			CPUs:       t.Cpus,
+			Memory:     t.Memory,
+			GPUs:       t.Gpus,
+			Authorized: t.Authorized == nil || *t.Authorized,
+		})
+	}
+	slices.SortFunc(types, func(a, b cloud.InstanceType) int {
+		if c := cmp.Compare(a.CPUs, b.CPUs); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Memory, b.Memory)
+	})
+	return types, nil
+}
+
+// ListTemplates returns the public templates and those private to the tenant.
+func (a *APIAccess) ListTemplates(ctx context.Context) ([]cloud.Template, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	templates := make([]cloud.Template, 0)
+	for _, visibility := range []v3.ListTemplatesVisibility{
+		v3.ListTemplatesVisibilityPublic,
+		v3.ListTemplatesVisibilityPrivate,
+	} {
+		resp, err := client.ListTemplates(ctx, v3.ListTemplatesWithVisibility(visibility))
+		if err != nil {
+			return nil, errorf("list %s templates: %w", visibility, err)
+		}
+		for _, t := range resp.Templates {
+			templates = append(templates, cloud.Template{
+				ID:          t.ID.String(),
+				Name:        t.Name,
+				Family:      t.Family,
+				DefaultUser: t.DefaultUser,
+				Visibility:  string(t.Visibility),
+				Size:        t.Size,
+			})
+		}
+	}
+	return templates, nil
+}
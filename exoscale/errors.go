@@ -0,0 +1,159 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	v3 "github.com/exoscale/egoscale/v3"
+)
+
+const (
+	// DefaultCallTimeout is how long a call to Exoscale, including its
+	// retries, may take unless configured otherwise using SetCallTimeout.
+	DefaultCallTimeout = 30 * time.Second
+	// DefaultRetries is how often reads are retried unless configured
+	// otherwise using SetRetries.
+	DefaultRetries = 3
+)
+
+var (
+	callTimeout atomic.Int64
+	retries     atomic.Int64
+	// retryBackoff is the delay before the first retry, which doubles with
+	// every further retry up to maxRetryBackoff.
+	retryBackoff    = 250 * time.Millisecond
+	maxRetryBackoff = 8 * time.Second
+)
+
+func init() {
+	callTimeout.Store(int64(DefaultCallTimeout))
+	retries.Store(DefaultRetries)
+}
+
+// SetCallTimeout changes how long a call to Exoscale, including its retries,
+// may take. It only applies to clients created afterwards, so it should be
+// called at startup. Waiting for an operation is not limited by it, but by
+// the context passed.
+func SetCallTimeout(timeout time.Duration) {
+	callTimeout.Store(int64(timeout))
+}
+
+// SetRetries changes how often reads failing due to rate limiting or server
+// errors are retried. Calls changing resources are never retried.
+func SetRetries(n int) {
+	retries.Store(int64(n))
+}
+
+// classified keeps the message of the wrapped error, but also matches the kind
+// of error it was classified as.
+type classified struct {
+	kind error
+	err  error
+}
+
+func (c *classified) Error() string {
+	return c.err.Error()
+}
+
+func (c *classified) Unwrap() []error {
+	return []error{c.kind, c.err}
+}
+
+// errorf formats an error like fmt.Errorf and classifies the error wrapped
+// according to the errors defined by the cloud package, if possible.
+func errorf(format string, args ...any) error {
+	return classify(fmt.Errorf(format, args...))
+}
+
+func classify(err error) error {
+	var kind error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, v3.ErrNotFound):
+		kind = cloud.ErrNotFound
+	case errors.Is(err, v3.ErrForbidden), errors.Is(err, v3.ErrUnauthorized):
+		kind = cloud.ErrForbidden
+	case errors.Is(err, v3.ErrTooManyRequests):
+		kind = cloud.ErrRateLimited
+	case errors.Is(err, v3.ErrInternalServerError), errors.Is(err, v3.ErrBadGateway),
+		errors.Is(err, v3.ErrServiceUnavailable), errors.Is(err, v3.ErrGatewayTimeout),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		kind = cloud.ErrUnavailable
+	default:
+		return err
+	}
+	return &classified{kind: kind, err: err}
+}
+
+// retryTransport retries reads that were rate limited or failed on the server
+// side with exponential backoff and jitter, honoring Retry-After. Other
+// requests are passed on once, since repeating them could e.g. create an
+// instance twice.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{
+		Timeout:   time.Duration(callTimeout.Load()),
+		Transport: &retryTransport{base: http.DefaultTransport},
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.base.RoundTrip(req)
+	}
+	limit := int(retries.Load())
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= limit || !retryable(req, resp, err) {
+			return resp, err
+		}
+		delay := backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns the delay before the retry following the given attempt: the
+// one asked for by Retry-After, if given in seconds, otherwise a random delay
+// of up to the doubled backoff of the previous attempt.
+func backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, maxRetryBackoff)
+		}
+	}
+	ceiling := min(retryBackoff<<attempt, maxRetryBackoff)
+	return ceiling/2 + rand.N(ceiling/2+1)
+}
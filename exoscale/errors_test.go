@@ -0,0 +1,88 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	v3 "github.com/exoscale/egoscale/v3"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{v3.ErrNotFound, cloud.ErrNotFound},
+		{v3.ErrForbidden, cloud.ErrForbidden},
+		{v3.ErrUnauthorized, cloud.ErrForbidden},
+		{v3.ErrTooManyRequests, cloud.ErrRateLimited},
+		{v3.ErrServiceUnavailable, cloud.ErrUnavailable},
+		{context.DeadlineExceeded, cloud.ErrUnavailable},
+	}
+	for _, test := range tests {
+		err := errorf("get instance %s: %w", "5f1c", test.err)
+		if !errors.Is(err, test.kind) || !errors.Is(err, test.err) {
+			t.Errorf("expected %v to be classified as %v", err, test.kind)
+		}
+		if err.Error() != "get instance 5f1c: "+test.err.Error() {
+			t.Errorf("expected message to be kept, got %s", err)
+		}
+	}
+	err := errorf("get instance: %w", v3.ErrBadRequest)
+	for _, kind := range []error{cloud.ErrNotFound, cloud.ErrForbidden, cloud.ErrRateLimited, cloud.ErrUnavailable} {
+		if errors.Is(err, kind) {
+			t.Errorf("expected %v not to be classified as %v", err, kind)
+		}
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	defer func(backoff, max time.Duration) { retryBackoff, maxRetryBackoff = backoff, max }(retryBackoff, maxRetryBackoff)
+	retryBackoff, maxRetryBackoff = time.Millisecond, time.Millisecond
+	var calls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	defer server.Close()
+	client := newHTTPClient()
+
+	resp, err := client.Get(server.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected read to succeed after retries, got %v (%v)", resp, err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 3 {
+		t.Errorf("expected 3 calls, got %d", calls.Load())
+	}
+
+	calls.Store(0)
+	resp, err = client.Post(server.URL, "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected failed write to be passed on, got %v (%v)", resp, err)
+	}
+	resp.Body.Close()
+	if calls.Load() != 1 {
+		t.Errorf("expected write not to be retried, got %d calls", calls.Load())
+	}
+
+	calls.Store(-10)
+	resp, err = client.Get(server.URL)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected last failure to be passed on, got %v (%v)", resp, err)
+	}
+	resp.Body.Close()
+	if calls.Load() != -10+DefaultRetries+1 {
+		t.Errorf("expected %d calls, got %d", DefaultRetries+1, calls.Load()+10)
+	}
+}
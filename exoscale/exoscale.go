@@ -2,126 +2,693 @@ package exoscale
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/composed-ch/cloud-castle-backend/cloud"
 	v3 "github.com/exoscale/egoscale/v3"
 	"github.com/exoscale/egoscale/v3/credentials"
 )
 
+// APIAccess is the cloud.Provider for Exoscale.
 type APIAccess struct {
-	Username string
-	Zone     string
-	Key      string
-	Secret   string
-	Creds    *credentials.Credentials
+	Zone   string
+	Key    string
+	Secret string
+	Creds  *credentials.Credentials
 }
 
-func NewAPIAccess(username, zone, key, secret string) *APIAccess {
+var _ cloud.Provider = (*APIAccess)(nil)
+
+func NewAPIAccess(zone, key, secret string) *APIAccess {
 	return &APIAccess{
-		Username: username,
-		Zone:     zone,
-		Key:      key,
-		Secret:   secret,
-		Creds:    credentials.NewStaticCredentials(key, secret),
+		Zone:   zone,
+		Key:    key,
+		Secret: secret,
+		Creds:  credentials.NewStaticCredentials(key, secret),
 	}
 }
 
-type Instance struct {
-	ID     string            `json:"id"`
-	Name   string            `json:"name"`
-	Labels map[string]string `json:"labels"`
-	IP     string            `json:"ip"`
-	State  string            `json:"state"`
+// NewProvider is the cloud.Factory for Exoscale.
+func NewProvider(creds cloud.Credentials) cloud.Provider {
+	return NewAPIAccess(creds.Zone, creds.Key, creds.Secret)
 }
 
+// GetClient returns the client for the access' credentials and zone, which is
+// shared by all accesses with the same credentials and zone.
 func (a *APIAccess) GetClient() (*v3.Client, error) {
-	return v3.NewClient(a.Creds, v3.ClientOptWithEndpoint(v3.Endpoint(fmt.Sprintf("https://api-%s.exoscale.com/v2", a.Zone))))
+	key := a.clientKey()
+	if client, ok := clients.Load(key); ok {
+		return client.(*v3.Client), nil
+	}
+	client, err := a.newClient()
+	if err != nil {
+		return nil, err
+	}
+	shared, _ := clients.LoadOrStore(key, client)
+	return shared.(*v3.Client), nil
 }
 
-func (a *APIAccess) GetInstances() ([]*Instance, error) {
+// GetInstances lists the instances of the zone. Listings are cached for a short
+// time and shared by concurrent callers; changes made through this package
+// invalidate the cache. A shared listing is not canceled along with the
+// context of the caller that started it, but is still bounded by the call
+// timeout.
+func (a *APIAccess) GetInstances(ctx context.Context) ([]*cloud.Instance, error) {
+	return instances.get(ctx, a.clientKey(), a.listInstances)
+}
+
+func (a *APIAccess) listInstances(ctx context.Context) ([]*cloud.Instance, error) {
 	client, err := a.GetClient()
-	instances := make([]*Instance, 0)
+	instances := make([]*cloud.Instance, 0)
 	if err != nil {
 		return nil, fmt.Errorf("create client: %w", err)
 	}
-	resp, err := client.ListInstances(context.Background())
+	resp, err := client.ListInstances(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("list instancers: %w", err)
+		return nil, errorf("list instancers: %w", err)
 	}
 	for _, instance := range resp.Instances {
-		instances = append(instances, fromListInstance(&instance))
+		instances = append(instances, fromListInstance(&instance, a.Zone))
 	}
 	return instances, nil
 }
 
-func (a *APIAccess) GetOwnInstances(username string) ([]*Instance, error) {
-	instances, err := a.GetInstances()
+func (a *APIAccess) GetInstance(ctx context.Context, id string) (*cloud.Instance, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	instance, err := client.GetInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return nil, errorf("get instance %s: %w", id, err)
+	}
+	return fromInstance(instance, a.Zone), nil
+}
+
+// StartInstance requests the instance to be started and returns the ID of the
+// operation, which can be awaited using WaitForOperation.
+func (a *APIAccess) StartInstance(ctx context.Context, id string) (string, error) {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
 	if err != nil {
-		return nil, fmt.Errorf("get own instances of %s: %w", username, err)
+		return "", fmt.Errorf("create client: %w", err)
 	}
-	ownInstances := make([]*Instance, 0)
-	for _, instance := range instances {
-		if owner, ok := instance.Labels["owner"]; ok {
-			if owner == username {
-				ownInstances = append(ownInstances, instance)
-			}
+	op, err := client.StartInstance(ctx, v3.UUID(id), v3.StartInstanceRequest{})
+	if err != nil {
+		return "", errorf("start instance %s: %w", id, err)
+	}
+	return op.ID.String(), nil
+}
+
+// StopInstance requests the instance to be stopped and returns the ID of the
+// operation, which can be awaited using WaitForOperation.
+func (a *APIAccess) StopInstance(ctx context.Context, id string) (string, error) {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.StopInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return "", errorf("stop instance %s: %w", id, err)
+	}
+	return op.ID.String(), nil
+}
+
+// RebootInstance requests the instance to be rebooted and returns the ID of the
+// operation, which can be awaited using WaitForOperation.
+func (a *APIAccess) RebootInstance(ctx context.Context, id string) (string, error) {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.RebootInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return "", errorf("reboot instance %s: %w", id, err)
+	}
+	return op.ID.String(), nil
+}
+
+// WaitForOperation waits until the operation succeeded, or returns an error if
+// it failed or did not finish within the timeout.
+func (a *APIAccess) WaitForOperation(ctx context.Context, id string, timeout time.Duration) error {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	op := &v3.Operation{ID: v3.UUID(id), State: v3.OperationStatePending}
+	if _, err := client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for operation %s: %w", id, err)
+	}
+	return nil
+}
+
+// ForceStopInstance stops the instance and waits for it to be stopped. The
+// Exoscale API offers no separate forced power-off, so unlike StopInstance,
+// the stop request is repeated if the instance, e.g. hanging during shutdown,
+// is not stopped within the timeout.
+func (a *APIAccess) ForceStopInstance(ctx context.Context, id string, timeout time.Duration) error {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		op, err := client.StopInstance(attemptCtx, v3.UUID(id))
+		if err == nil {
+			_, err = client.Wait(attemptCtx, op, v3.OperationStateSuccess)
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errorf("force stop instance %s: %w", id, lastErr)
+}
+
+// DeleteInstance destroys the instance and waits until it is gone.
+func (a *APIAccess) DeleteInstance(ctx context.Context, id string) error {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.DeleteInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return errorf("delete instance %s: %w", id, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for deletion of instance %s: %w", id, err)
+	}
+	return nil
+}
+
+// UpdateInstanceLabels replaces the labels of the instance and waits until it
+// is done.
+func (a *APIAccess) UpdateInstanceLabels(ctx context.Context, id string, labels map[string]string) error {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.UpdateInstance(ctx, v3.UUID(id), v3.UpdateInstanceRequest{Labels: labels})
+	if err != nil {
+		return errorf("update labels of instance %s: %w", id, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for label update of instance %s: %w", id, err)
+	}
+	return nil
+}
+
+// CreateSnapshot takes a snapshot of the instance's disk, waits until it is
+// done and returns the snapshot's ID.
+func (a *APIAccess) CreateSnapshot(ctx context.Context, id string) (string, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.CreateSnapshot(ctx, v3.UUID(id))
+	if err != nil {
+		return "", errorf("create snapshot of instance %s: %w", id, err)
+	}
+	op, err = client.Wait(ctx, op, v3.OperationStateSuccess)
+	if err != nil {
+		return "", errorf("wait for snapshot of instance %s: %w", id, err)
+	}
+	if op.Reference == nil {
+		return "", fmt.Errorf("snapshot of instance %s: operation %s lacks reference", id, op.ID)
+	}
+	return op.Reference.ID.String(), nil
+}
+
+// ResetInstance reinstalls the instance from the template, or from the
+// template it was created from if templateId is empty, keeping its ID and IP
+// address. All data on the disk is lost. The ID of the template used is
+// returned.
+func (a *APIAccess) ResetInstance(ctx context.Context, id, templateId, userData string) (string, error) {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	instance, err := client.GetInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return "", errorf("get instance %s: %w", id, err)
+	}
+	if templateId == "" {
+		if instance.Template == nil {
+			return "", fmt.Errorf("instance %s has no template", id)
+		}
+		templateId = instance.Template.ID.String()
+	}
+	if userData != "" {
+		// the labels are sent along, since the update replaces them
+		op, err := client.UpdateInstance(ctx, v3.UUID(id), v3.UpdateInstanceRequest{
+			Labels:   instance.Labels,
+			UserData: base64.StdEncoding.EncodeToString([]byte(userData)),
+		})
+		if err != nil {
+			return "", errorf("update user-data of instance %s: %w", id, err)
+		}
+		if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+			return "", errorf("wait for user-data update of instance %s: %w", id, err)
+		}
+	}
+	op, err := client.ResetInstance(ctx, v3.UUID(id),
+		v3.ResetInstanceRequest{Template: &v3.Template{ID: v3.UUID(templateId)}})
+	if err != nil {
+		return "", errorf("reset instance %s: %w", id, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return "", errorf("wait for reset of instance %s: %w", id, err)
+	}
+	return templateId, nil
+}
+
+// ResetInstancePassword has a new password generated for the instance's
+// default user and waits until it is done.
+func (a *APIAccess) ResetInstancePassword(ctx context.Context, id string) error {
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.ResetInstancePassword(ctx, v3.UUID(id))
+	if err != nil {
+		return errorf("reset password of instance %s: %w", id, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for password reset of instance %s: %w", id, err)
+	}
+	return nil
+}
+
+func (a *APIAccess) RevealInstancePassword(ctx context.Context, id string) (string, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	resp, err := client.RevealInstancePassword(ctx, v3.UUID(id))
+	if err != nil {
+		return "", errorf("reveal password of instance %s: %w", id, err)
+	}
+	return resp.Password, nil
+}
+
+// consoleURLValidity is how long Exoscale accepts a signed console URL.
+const consoleURLValidity = time.Minute
+
+func (a *APIAccess) GetConsoleURL(ctx context.Context, id string) (*cloud.ConsoleURL, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	requested := time.Now()
+	resp, err := client.GetConsoleProxyURL(ctx, v3.UUID(id))
+	if err != nil {
+		return nil, errorf("get console URL of instance %s: %w", id, err)
+	}
+	return &cloud.ConsoleURL{URL: resp.URL, Expires: requested.Add(consoleURLValidity)}, nil
+}
+
+// GetSnapshots lists the snapshots of the instance.
+func (a *APIAccess) GetSnapshots(ctx context.Context, instanceId string) ([]*cloud.Snapshot, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	resp, err := client.ListSnapshots(ctx)
+	if err != nil {
+		return nil, errorf("list snapshots: %w", err)
+	}
+	snapshots := make([]*cloud.Snapshot, 0)
+	for _, snapshot := range resp.Snapshots {
+		if snapshot.Instance != nil && snapshot.Instance.ID.String() == instanceId {
+			snapshots = append(snapshots, fromSnapshot(&snapshot))
 		}
 	}
-	return ownInstances, nil
+	return snapshots, nil
 }
 
-func (a *APIAccess) GetInstance(id string) (*Instance, error) {
+func (a *APIAccess) GetSnapshot(ctx context.Context, id string) (*cloud.Snapshot, error) {
 	client, err := a.GetClient()
 	if err != nil {
 		return nil, fmt.Errorf("create client: %w", err)
 	}
-	instance, err := client.GetInstance(context.Background(), v3.UUID(id))
+	snapshot, err := client.GetSnapshot(ctx, v3.UUID(id))
 	if err != nil {
-		return nil, fmt.Errorf("get instance %s: %w", id, err)
+		return nil, errorf("get snapshot %s: %w", id, err)
 	}
-	return fromInstance(instance), nil
+	return fromSnapshot(snapshot), nil
 }
 
-func (a *APIAccess) StartInstance(id string) error {
+// RevertToSnapshot restores the disk of the stopped instance to the state of
+// the snapshot and waits until it is done.
+func (a *APIAccess) RevertToSnapshot(ctx context.Context, instanceId, snapshotId string) error {
 	client, err := a.GetClient()
 	if err != nil {
 		return fmt.Errorf("create client: %w", err)
 	}
-	_, err = client.StartInstance(context.Background(), v3.UUID(id), v3.StartInstanceRequest{})
+	op, err := client.RevertInstanceToSnapshot(ctx, v3.UUID(instanceId),
+		v3.RevertInstanceToSnapshotRequest{ID: v3.UUID(snapshotId)})
 	if err != nil {
-		return fmt.Errorf("start instance %s: %w", id, err)
+		return errorf("revert instance %s to snapshot %s: %w", instanceId, snapshotId, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for revert of instance %s to snapshot %s: %w", instanceId, snapshotId, err)
 	}
 	return nil
 }
 
-func (a *APIAccess) StopInstance(id string) error {
+func (a *APIAccess) DeleteSnapshot(ctx context.Context, id string) error {
 	client, err := a.GetClient()
 	if err != nil {
 		return fmt.Errorf("create client: %w", err)
 	}
-	_, err = client.StopInstance(context.Background(), v3.UUID(id))
+	op, err := client.DeleteSnapshot(ctx, v3.UUID(id))
 	if err != nil {
-		return fmt.Errorf("start instance %s: %w", id, err)
+		return errorf("delete snapshot %s: %w", id, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for deletion of snapshot %s: %w", id, err)
 	}
 	return nil
 }
 
-func fromListInstance(instance *v3.ListInstancesResponseInstances) *Instance {
-	return &Instance{
-		ID:     instance.ID.String(),
-		Name:   instance.Name,
-		Labels: instance.Labels,
-		IP:     instance.PublicIP.To4().String(),
-		State:  string(instance.State),
+// EnsureSSHKey registers the public key under the name, unless a key of that
+// name is registered already.
+func (a *APIAccess) EnsureSSHKey(ctx context.Context, name, publicKey string) error {
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	if _, err := client.GetSSHKey(ctx, name); err == nil {
+		return nil
+	} else if err := errorf("get SSH key %s: %w", name, err); !errors.Is(err, cloud.ErrNotFound) {
+		return err
+	}
+	op, err := client.RegisterSSHKey(ctx, v3.RegisterSSHKeyRequest{Name: name, PublicKey: publicKey})
+	if err != nil {
+		return errorf("register SSH key %s: %w", name, err)
 	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for registration of SSH key %s: %w", name, err)
+	}
+	return nil
 }
 
-func fromInstance(instance *v3.Instance) *Instance {
-	return &Instance{
-		ID:     instance.ID.String(),
-		Name:   instance.Name,
-		Labels: instance.Labels,
-		IP:     instance.PublicIP.To4().String(),
-		State:  string(instance.State),
+func (a *APIAccess) DeleteSSHKey(ctx context.Context, name string) error {
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.DeleteSSHKey(ctx, name)
+	if err != nil {
+		return errorf("delete SSH key %s: %w", name, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for deletion of SSH key %s: %w", name, err)
+	}
+	return nil
+}
+
+// CreateInstance creates and starts an instance and waits until it exists.
+func (a *APIAccess) CreateInstance(ctx context.Context, spec cloud.InstanceSpec) (*cloud.Instance, error) {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	instanceType, err := findInstanceType(ctx, client, spec.InstanceType)
+	if err != nil {
+		return nil, err
+	}
+	req := v3.CreateInstanceRequest{
+		Name:         spec.Name,
+		DiskSize:     spec.DiskSize,
+		InstanceType: instanceType,
+		Template:     &v3.Template{ID: v3.UUID(spec.TemplateID)},
+		Labels:       spec.Labels,
+	}
+	if len(spec.SecurityGroups) > 0 {
+		groups, err := client.ListSecurityGroups(ctx)
+		if err != nil {
+			return nil, errorf("list security groups: %w", err)
+		}
+		for _, nameOrID := range spec.SecurityGroups {
+			group, err := groups.FindSecurityGroup(nameOrID)
+			if err != nil {
+				return nil, errorf("find security group %s: %w", nameOrID, err)
+			}
+			req.SecurityGroups = append(req.SecurityGroups, v3.SecurityGroup{ID: group.ID})
+		}
+	}
+	for _, name := range spec.SSHKeys {
+		req.SSHKeys = append(req.SSHKeys, v3.SSHKey{Name: name})
+	}
+	if spec.UserData != "" {
+		req.UserData = base64.StdEncoding.EncodeToString([]byte(spec.UserData))
+	}
+	op, err := client.CreateInstance(ctx, req)
+	if err != nil {
+		return nil, errorf("create instance %s: %w", spec.Name, err)
+	}
+	op, err = client.Wait(ctx, op, v3.OperationStateSuccess)
+	if err != nil {
+		return nil, errorf("wait for creation of instance %s: %w", spec.Name, err)
+	}
+	if op.Reference == nil {
+		return nil, fmt.Errorf("creation of instance %s: operation %s lacks reference", spec.Name, op.ID)
+	}
+	return a.GetInstance(ctx, op.Reference.ID.String())
+}
+
+// GetInstanceDetail returns the instance with the names of its instance type,
+// template, security groups and private networks resolved.
+func (a *APIAccess) GetInstanceDetail(ctx context.Context, id string) (*cloud.Instance, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	resp, err := client.GetInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return nil, errorf("get instance %s: %w", id, err)
+	}
+	instance := fromInstance(resp, a.Zone)
+	if instance.InstanceType != nil {
+		instanceType, err := client.GetInstanceType(ctx, v3.UUID(instance.InstanceType.ID))
+		if err != nil {
+			return nil, errorf("get instance type %s: %w", instance.InstanceType.ID, err)
+		}
+		instance.InstanceType = fromInstanceType(instanceType)
+	}
+	if instance.Template != nil {
+		template, err := client.GetTemplate(ctx, v3.UUID(instance.Template.ID))
+		if err != nil {
+			return nil, errorf("get template %s: %w", instance.Template.ID, err)
+		}
+		instance.Template = fromTemplate(template)
+	}
+	if len(instance.SecurityGroups) > 0 {
+		groups, err := client.ListSecurityGroups(ctx)
+		if err != nil {
+			return nil, errorf("list security groups: %w", err)
+		}
+		for i, ref := range instance.SecurityGroups {
+			if group, err := groups.FindSecurityGroup(ref.ID); err == nil {
+				instance.SecurityGroups[i].Name = group.Name
+			}
+		}
+	}
+	if len(instance.PrivateNetworks) > 0 {
+		networks, err := client.ListPrivateNetworks(ctx)
+		if err != nil {
+			return nil, errorf("list private networks: %w", err)
+		}
+		for i, ref := range instance.PrivateNetworks {
+			if network, err := networks.FindPrivateNetwork(ref.ID); err == nil {
+				instance.PrivateNetworks[i].Name = network.Name
+			}
+		}
+	}
+	return instance, nil
+}
+
+// GetInstanceTypeName returns the type of the instance as family and size,
+// e.g. "standard.small".
+func (a *APIAccess) GetInstanceTypeName(ctx context.Context, id string) (string, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	instance, err := client.GetInstance(ctx, v3.UUID(id))
+	if err != nil {
+		return "", errorf("get instance %s: %w", id, err)
+	}
+	if instance.InstanceType == nil {
+		return "", fmt.Errorf("instance %s lacks an instance type", id)
+	}
+	instanceType, err := client.GetInstanceType(ctx, instance.InstanceType.ID)
+	if err != nil {
+		return "", errorf("get instance type %s: %w", instance.InstanceType.ID, err)
+	}
+	return fmt.Sprintf("%s.%s", instanceType.Family, instanceType.Size), nil
+}
+
+// ScaleInstance changes the type of the stopped instance to the one given as
+// family and size, which has to be of the instance's current family, and waits
+// until it is done.
+func (a *APIAccess) ScaleInstance(ctx context.Context, id, instanceType string) error {
+	defer a.invalidateInstances()
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	target, err := findInstanceType(ctx, client, instanceType)
+	if err != nil {
+		return err
+	}
+	op, err := client.ScaleInstance(ctx, v3.UUID(id), v3.ScaleInstanceRequest{InstanceType: target})
+	if err != nil {
+		return errorf("scale instance %s to %s: %w", id, instanceType, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for scaling of instance %s: %w", id, err)
+	}
+	return nil
+}
+
+// findInstanceType looks up an instance type given as family and size, e.g.
+// "standard.small".
+func findInstanceType(ctx context.Context, client *v3.Client, name string) (*v3.InstanceType, error) {
+	family, size, ok := strings.Cut(name, ".")
+	if !ok {
+		return nil, fmt.Errorf("instance type '%s' is not of the form family.size", name)
+	}
+	resp, err := client.ListInstanceTypes(ctx)
+	if err != nil {
+		return nil, errorf("list instance types: %w", err)
+	}
+	for _, instanceType := range resp.InstanceTypes {
+		if string(instanceType.Family) == family && string(instanceType.Size) == size {
+			return &v3.InstanceType{ID: instanceType.ID}, nil
+		}
+	}
+	return nil, fmt.Errorf("no instance type '%s' found", name)
+}
+
+func fromListInstance(instance *v3.ListInstancesResponseInstances, zone string) *cloud.Instance {
+	networks := make([]cloud.Reference, 0, len(instance.PrivateNetworks))
+	for _, network := range instance.PrivateNetworks {
+		networks = append(networks, cloud.Reference{ID: network.ID.String()})
+	}
+	return &cloud.Instance{
+		ID:              instance.ID.String(),
+		Name:            instance.Name,
+		Labels:          instance.Labels,
+		IP:              instance.PublicIP.To4().String(),
+		State:           string(instance.State),
+		IPv6:            instance.Ipv6Address,
+		Zone:            zone,
+		Created:         instance.CreatedAT,
+		InstanceType:    fromInstanceType(instance.InstanceType),
+		Template:        fromTemplate(instance.Template),
+		SecurityGroups:  fromSecurityGroups(instance.SecurityGroups),
+		PrivateNetworks: networks,
+		SSHKeys:         fromSSHKeys(instance.SSHKey, instance.SSHKeys),
+	}
+}
+
+func fromInstance(instance *v3.Instance, zone string) *cloud.Instance {
+	networks := make([]cloud.Reference, 0, len(instance.PrivateNetworks))
+	for _, network := range instance.PrivateNetworks {
+		networks = append(networks, cloud.Reference{ID: network.ID.String()})
+	}
+	return &cloud.Instance{
+		ID:              instance.ID.String(),
+		Name:            instance.Name,
+		Labels:          instance.Labels,
+		IP:              instance.PublicIP.To4().String(),
+		State:           string(instance.State),
+		IPv6:            instance.Ipv6Address,
+		Zone:            zone,
+		Created:         instance.CreatedAT,
+		InstanceType:    fromInstanceType(instance.InstanceType),
+		Template:        fromTemplate(instance.Template),
+		DiskSize:        instance.DiskSize,
+		SecurityGroups:  fromSecurityGroups(instance.SecurityGroups),
+		PrivateNetworks: networks,
+		SSHKeys:         fromSSHKeys(instance.SSHKey, instance.SSHKeys),
+	}
+}
+
+func fromInstanceType(instanceType *v3.InstanceType) *cloud.Reference {
+	if instanceType == nil {
+		return nil
+	}
+	ref := cloud.Reference{ID: instanceType.ID.String()}
+	if instanceType.Family != "" && instanceType.Size != "" {
+		ref.Name = fmt.Sprintf("%s.%s", instanceType.Family, instanceType.Size)
+	}
+	return &ref
+}
+
+func fromTemplate(template *v3.Template) *cloud.Reference {
+	if template == nil {
+		return nil
+	}
+	return &cloud.Reference{ID: template.ID.String(), Name: template.Name}
+}
+
+func fromSecurityGroups(groups []v3.SecurityGroup) []cloud.Reference {
+	refs := make([]cloud.Reference, 0, len(groups))
+	for _, group := range groups {
+		refs = append(refs, cloud.Reference{ID: group.ID.String(), Name: group.Name})
+	}
+	return refs
+}
+
+// fromSSHKeys returns the names of the instance's SSH keys, of which the API
+// reports the first one separately.
+func fromSSHKeys(key *v3.SSHKey, keys []v3.SSHKey) []string {
+	names := make([]string, 0, len(keys)+1)
+	if key != nil && key.Name != "" {
+		names = append(names, key.Name)
+	}
+	for _, k := range keys {
+		if !slices.Contains(names, k.Name) {
+			names = append(names, k.Name)
+		}
+	}
+	return names
+}
+
+func fromSnapshot(snapshot *v3.Snapshot) *cloud.Snapshot {
+	var instanceId string
+	if snapshot.Instance != nil {
+		instanceId = snapshot.Instance.ID.String()
+	}
+	return &cloud.Snapshot{
+		ID:         snapshot.ID.String(),
+		Name:       snapshot.Name,
+		InstanceID: instanceId,
+		Created:    snapshot.CreatedAT,
+		Size:       snapshot.Size,
+		State:      string(snapshot.State),
 	}
 }
@@ -0,0 +1,147 @@
+package exoscale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	v3 "github.com/exoscale/egoscale/v3"
+)
+
+// instanceSecurityGroupPrefix is prepended to the instance ID to name the
+// security group holding the rules requested for that instance.
+const instanceSecurityGroupPrefix = "cloud-castle-"
+
+// EnsureInstanceSecurityGroup returns the ID of the instance's own security
+// group, creating it and attaching it to the instance if necessary.
+func (a *APIAccess) EnsureInstanceSecurityGroup(ctx context.Context, instanceId string) (string, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	name := instanceSecurityGroupPrefix + instanceId
+	groups, err := client.ListSecurityGroups(ctx)
+	if err != nil {
+		return "", errorf("list security groups: %w", err)
+	}
+	var groupId v3.UUID
+	group, err := groups.FindSecurityGroup(name)
+	if errors.Is(err, v3.ErrNotFound) {
+		op, err := client.CreateSecurityGroup(ctx, v3.CreateSecurityGroupRequest{
+			Name:        name,
+			Description: "Rules requested for instance " + instanceId,
+		})
+		if err != nil {
+			return "", errorf("create security group %s: %w", name, err)
+		}
+		op, err = client.Wait(ctx, op, v3.OperationStateSuccess)
+		if err != nil {
+			return "", errorf("wait for creation of security group %s: %w", name, err)
+		}
+		if op.Reference == nil {
+			return "", fmt.Errorf("creation of security group %s: operation %s lacks reference", name, op.ID)
+		}
+		groupId = op.Reference.ID
+	} else if err != nil {
+		return "", errorf("find security group %s: %w", name, err)
+	} else {
+		groupId = group.ID
+	}
+	instance, err := client.GetInstance(ctx, v3.UUID(instanceId))
+	if err != nil {
+		return "", errorf("get instance %s: %w", instanceId, err)
+	}
+	attached := slices.ContainsFunc(instance.SecurityGroups, func(g v3.SecurityGroup) bool {
+		return g.ID == groupId
+	})
+	if !attached {
+		op, err := client.AttachInstanceToSecurityGroup(ctx, groupId, v3.AttachInstanceToSecurityGroupRequest{
+			Instance: &v3.Instance{ID: v3.UUID(instanceId)},
+		})
+		if err != nil {
+			return "", errorf("attach instance %s to security group %s: %w", instanceId, name, err)
+		}
+		if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+			return "", errorf("wait for attachment of instance %s to security group %s: %w", instanceId, name, err)
+		}
+	}
+	return groupId.String(), nil
+}
+
+// AddIngressRule adds the rule to the security group and returns the ID of the
+// rule, which is found by its description.
+func (a *APIAccess) AddIngressRule(ctx context.Context, groupId string, rule cloud.IngressRule) (string, error) {
+	client, err := a.GetClient()
+	if err != nil {
+		return "", fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.AddRuleToSecurityGroup(ctx, v3.UUID(groupId), v3.AddRuleToSecurityGroupRequest{
+		Description:   rule.Description,
+		FlowDirection: v3.AddRuleToSecurityGroupRequestFlowDirectionIngress,
+		Protocol:      v3.AddRuleToSecurityGroupRequestProtocol(rule.Protocol),
+		StartPort:     rule.Port,
+		EndPort:       rule.Port,
+		Network:       rule.Network,
+	})
+	if err != nil {
+		return "", errorf("add rule to security group %s: %w", groupId, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return "", errorf("wait for rule of security group %s: %w", groupId, err)
+	}
+	group, err := client.GetSecurityGroup(ctx, v3.UUID(groupId))
+	if err != nil {
+		return "", errorf("get security group %s: %w", groupId, err)
+	}
+	for _, r := range group.Rules {
+		if r.Description == rule.Description {
+			return r.ID.String(), nil
+		}
+	}
+	return "", fmt.Errorf("rule '%s' not found in security group %s", rule.Description, groupId)
+}
+
+func (a *APIAccess) DeleteSecurityGroupRule(ctx context.Context, groupId, ruleId string) error {
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	op, err := client.DeleteRuleFromSecurityGroup(ctx, v3.UUID(groupId), v3.UUID(ruleId))
+	if err != nil {
+		return errorf("delete rule %s from security group %s: %w", ruleId, groupId, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for deletion of rule %s: %w", ruleId, err)
+	}
+	return nil
+}
+
+// DeleteInstanceSecurityGroup removes the instance's own security group, if
+// there is one. The instance must not be attached to it anymore.
+func (a *APIAccess) DeleteInstanceSecurityGroup(ctx context.Context, instanceId string) error {
+	client, err := a.GetClient()
+	if err != nil {
+		return fmt.Errorf("create client: %w", err)
+	}
+	name := instanceSecurityGroupPrefix + instanceId
+	groups, err := client.ListSecurityGroups(ctx)
+	if err != nil {
+		return errorf("list security groups: %w", err)
+	}
+	group, err := groups.FindSecurityGroup(name)
+	if errors.Is(err, v3.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return errorf("find security group %s: %w", name, err)
+	}
+	op, err := client.DeleteSecurityGroup(ctx, group.ID)
+	if err != nil {
+		return errorf("delete security group %s: %w", name, err)
+	}
+	if _, err = client.Wait(ctx, op, v3.OperationStateSuccess); err != nil {
+		return errorf("wait for deletion of security group %s: %w", name, err)
+	}
+	return nil
+}
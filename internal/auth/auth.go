@@ -32,6 +32,38 @@ func IssueToken(username string) (string, error) {
 
 }
 
+// IssueConfirmationToken issues a short-lived token by which the user confirms
+// a destructive action on a resource.
+func IssueConfirmationToken(username, action, resource string) (string, time.Time, error) {
+	iat := time.Now()
+	exp := iat.Add(time.Minute * 5)
+	token := jwt.NewWithClaims(signingMethod, jwt.MapClaims{
+		"sub": username,
+		"act": action,
+		"res": resource,
+		"iat": iat.Unix(),
+		"exp": exp.Unix(),
+	})
+	signed, err := token.SignedString([]byte(secret))
+	return signed, exp, err
+}
+
+// VerifyConfirmationToken checks that the token was issued to the user for the
+// action on the resource and has not expired yet.
+func VerifyConfirmationToken(tokenStr, username, action, resource string) error {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{signingMethod.Alg()}), jwt.WithSubject(username))
+	if err != nil {
+		return fmt.Errorf("parsing confirmation token: %w", err)
+	}
+	if claims["act"] != action || claims["res"] != resource {
+		return errors.New("confirmation token issued for another action or resource")
+	}
+	return nil
+}
+
 func Authenticated(handler Handler) Handler {
 	return func(w http.ResponseWriter, r *http.Request) {
 		authorization := strings.TrimSpace(r.Header.Get("Authorization"))
@@ -50,12 +82,16 @@ func ExtractSubject(authorization string) (string, error) {
 		return "", errors.New("extract bearer token")
 	}
 	tokenStr := matches[1]
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (any, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (any, error) {
 		return []byte(secret), nil
 	}, jwt.WithValidMethods([]string{signingMethod.Alg()}))
 	if err != nil {
 		return "", fmt.Errorf("parsing token: %w", err)
 	}
+	if _, ok := claims["act"]; ok {
+		return "", errors.New("confirmation token used for authentication")
+	}
 	subject, err := token.Claims.GetSubject()
 	if err != nil {
 		return "", fmt.Errorf("get subject from token: %w", err)
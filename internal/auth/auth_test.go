@@ -0,0 +1,31 @@
+package auth
+
+import "testing"
+
+func TestConfirmationToken(t *testing.T) {
+	token, _, err := IssueConfirmationToken("alice", "reset", "instance-1")
+	if err != nil {
+		t.Fatalf("issue confirmation token: %v", err)
+	}
+	tests := []struct {
+		username string
+		action   string
+		resource string
+		valid    bool
+	}{
+		{"alice", "reset", "instance-1", true},
+		{"bob", "reset", "instance-1", false},
+		{"alice", "delete", "instance-1", false},
+		{"alice", "reset", "instance-2", false},
+	}
+	for _, test := range tests {
+		err := VerifyConfirmationToken(token, test.username, test.action, test.resource)
+		if (err == nil) != test.valid {
+			t.Errorf(`expected VerifyConfirmationToken(%s, %s, %s) to be valid: %v, got error %v`,
+				test.username, test.action, test.resource, test.valid, err)
+		}
+	}
+	if _, err := ExtractSubject("Bearer " + token); err == nil {
+		t.Errorf("expected confirmation token to be rejected for authentication")
+	}
+}
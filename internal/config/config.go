@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/jackc/pgx/v5"
@@ -17,6 +18,26 @@ type Config struct {
 	DatabaseUser  string `env:"DATABASE_USER" envDefault:"cloud_castle"`
 	DatabasePass  string `env:"DATABASE_PASS" envDefault:"topsecret"`
 	PostmarkToken string `env:"POSTMARK_TOKEN" envDefault:"missingToken"`
+	// DeletionGracePeriod is the time between a deletion request and the
+	// actual destruction of an instance, during which it can be restored.
+	DeletionGracePeriod time.Duration `env:"DELETION_GRACE_PERIOD" envDefault:"24h"`
+	// CatalogTTL is how long zones, instance types and templates are cached.
+	CatalogTTL time.Duration `env:"CATALOG_TTL" envDefault:"1h"`
+	// StatePollInterval is how often instance states are polled for clients
+	// subscribed to state changes.
+	StatePollInterval time.Duration `env:"STATE_POLL_INTERVAL" envDefault:"5s"`
+	// InstanceCacheTTL is how long instance listings are reused; changes made
+	// through the backend invalidate them earlier.
+	InstanceCacheTTL time.Duration `env:"INSTANCE_CACHE_TTL" envDefault:"5s"`
+	// ExoscaleTimeout is how long a call to the Exoscale API, including its
+	// retries, may take.
+	ExoscaleTimeout time.Duration `env:"EXOSCALE_TIMEOUT" envDefault:"30s"`
+	// ExoscaleRetries is how often reads from the Exoscale API are retried if
+	// rate limited or failed on the server side.
+	ExoscaleRetries int `env:"EXOSCALE_RETRIES" envDefault:"3"`
+	// IdempotencyKeyTTL is how long responses are kept to be replayed for
+	// requests retried with the same idempotency key.
+	IdempotencyKeyTTL time.Duration `env:"IDEMPOTENCY_KEY_TTL" envDefault:"24h"`
 }
 
 func (c *Config) ConnectionString() string {
@@ -10,6 +10,11 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	ROLE_STUDENT = "student"
+	ROLE_TEACHER = "teacher"
+)
+
 type Account struct {
 	Id         int
 	Name       string
@@ -68,6 +73,26 @@ func LoadAccountByName(ctx context.Context, pool *pgxpool.Pool, name string) (*A
 	}, nil
 }
 
+func LoadAccountById(ctx context.Context, pool *pgxpool.Pool, id int) (*Account, error) {
+	var registered sql.NullTime
+	var name, role, password, tenant, email sql.NullString
+	err := pool.QueryRow(ctx,
+		"select name, role, registered, password, tenant, email from account where id = $1",
+		id).Scan(&name, &role, &registered, &password, &tenant, &email)
+	if err != nil {
+		return nil, fmt.Errorf("load account by id %d: %v", id, err)
+	}
+	return &Account{
+		Id:         id,
+		Name:       name.String,
+		Role:       role.String,
+		Registered: registered.Time,
+		Password:   password.String,
+		Tenant:     tenant.String,
+		Email:      email.String,
+	}, nil
+}
+
 func UpdatePassword(ctx context.Context, pool *pgxpool.Pool, name, password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Permission is the level of access an account has on an instance. Every
+// level includes the ones below it.
+type Permission string
+
+const (
+	PERMISSION_NONE    Permission = ""
+	PERMISSION_VIEW    Permission = "view"
+	PERMISSION_OPERATE Permission = "operate"
+	PERMISSION_OWNER   Permission = "owner"
+)
+
+var permissionRanks = map[Permission]int{
+	PERMISSION_NONE:    0,
+	PERMISSION_VIEW:    1,
+	PERMISSION_OPERATE: 2,
+	PERMISSION_OWNER:   3,
+}
+
+func (p Permission) Valid() bool {
+	_, ok := permissionRanks[p]
+	return ok && p != PERMISSION_NONE
+}
+
+// Includes returns true if p grants at least the required permission.
+func (p Permission) Includes(required Permission) bool {
+	return permissionRanks[p] >= permissionRanks[required]
+}
+
+func AssignInstanceToAccount(ctx context.Context, pool *pgxpool.Pool, instanceId, zone, tenant string, accountId int, permission Permission) error {
+	_, err := pool.Exec(ctx,
+		`insert into instance_assignment (instance_id, zone, tenant, account_id, permission)
+		values ($1, $2, $3, $4, $5)
+		on conflict (instance_id, account_id) do update set permission = excluded.permission`,
+		instanceId, zone, tenant, accountId, permission)
+	if err != nil {
+		return fmt.Errorf("assign instance %s to account %d: %v", instanceId, accountId, err)
+	}
+	return nil
+}
+
+func AssignInstanceToGroup(ctx context.Context, pool *pgxpool.Pool, instanceId, zone, tenant string, groupId int, permission Permission) error {
+	_, err := pool.Exec(ctx,
+		`insert into instance_assignment (instance_id, zone, tenant, group_id, permission)
+		values ($1, $2, $3, $4, $5)
+		on conflict (instance_id, group_id) do update set permission = excluded.permission`,
+		instanceId, zone, tenant, groupId, permission)
+	if err != nil {
+		return fmt.Errorf("assign instance %s to group %d: %v", instanceId, groupId, err)
+	}
+	return nil
+}
+
+// AssignOfferedInstance makes the account the owner of an instance it created
+// from an offering.
+func AssignOfferedInstance(ctx context.Context, pool *pgxpool.Pool, instanceId, zone, tenant string, accountId, offeringId int) error {
+	_, err := pool.Exec(ctx,
+		`insert into instance_assignment (instance_id, zone, tenant, account_id, permission, offering_id)
+		values ($1, $2, $3, $4, $5, $6)`,
+		instanceId, zone, tenant, accountId, PERMISSION_OWNER, offeringId)
+	if err != nil {
+		return fmt.Errorf("assign instance %s created from offering %d to account %d: %v", instanceId, offeringId, accountId, err)
+	}
+	return nil
+}
+
+// LoadOfferedInstanceOwner returns the id of the account that created the
+// instance from an offering, or -1 if it was not created from an offering.
+func LoadOfferedInstanceOwner(ctx context.Context, pool *pgxpool.Pool, instanceId string) (int, error) {
+	var accountId int
+	err := pool.QueryRow(ctx,
+		"select account_id from instance_assignment where instance_id = $1 and offering_id is not null limit 1",
+		instanceId).Scan(&accountId)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return -1, nil
+	} else if err != nil {
+		return -1, fmt.Errorf("load owner of offered instance %s: %v", instanceId, err)
+	}
+	return accountId, nil
+}
+
+// LoadAssignedInstances returns the highest permission the account has on
+// each instance, be it assigned to the account directly or to one of its
+// groups.
+func LoadAssignedInstances(ctx context.Context, pool *pgxpool.Pool, accountId int) (map[string]Permission, error) {
+	rows, err := pool.Query(ctx,
+		`select instance_id, permission from instance_assignment where account_id = $1
+		union
+		select instance_id, permission from instance_assignment
+		inner join group_member on instance_assignment.group_id = group_member.group_id
+		where group_member.account_id = $1`, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("load instances assigned to account %d: %v", accountId, err)
+	}
+	defer rows.Close()
+	assigned := make(map[string]Permission)
+	for rows.Next() {
+		var instanceId string
+		var permission Permission
+		if err := rows.Scan(&instanceId, &permission); err != nil {
+			return nil, fmt.Errorf("scan instance assignment: %v", err)
+		}
+		if !assigned[instanceId].Includes(permission) {
+			assigned[instanceId] = permission
+		}
+	}
+	return assigned, rows.Err()
+}
+
+// LoadInstancePermission returns the highest permission the account has on
+// the instance, or PERMISSION_NONE if the instance is not assigned to it.
+func LoadInstancePermission(ctx context.Context, pool *pgxpool.Pool, instanceId string, accountId int) (Permission, error) {
+	rows, err := pool.Query(ctx,
+		`select permission from instance_assignment where instance_id = $1 and account_id = $2
+		union
+		select permission from instance_assignment
+		inner join group_member on instance_assignment.group_id = group_member.group_id
+		where instance_id = $1 and group_member.account_id = $2`, instanceId, accountId)
+	if err != nil {
+		return PERMISSION_NONE, fmt.Errorf("load permission of account %d on instance %s: %v", accountId, instanceId, err)
+	}
+	defer rows.Close()
+	highest := PERMISSION_NONE
+	for rows.Next() {
+		var permission Permission
+		if err := rows.Scan(&permission); err != nil {
+			return PERMISSION_NONE, fmt.Errorf("scan instance permission: %v", err)
+		}
+		if !highest.Includes(permission) {
+			highest = permission
+		}
+	}
+	return highest, rows.Err()
+}
+
+// IsTeacherOfInstance returns true if the account is a teacher and a member of
+// a group the instance is assigned to, either directly or through one of the
+// group's members.
+func IsTeacherOfInstance(ctx context.Context, pool *pgxpool.Pool, instanceId string, accountId int) (bool, error) {
+	var teacher bool
+	err := pool.QueryRow(ctx,
+		`select exists (
+			select 1 from account
+			inner join group_member teacher on teacher.account_id = account.id
+			inner join instance_assignment on instance_assignment.instance_id = $1
+			left join group_member member on member.group_id = teacher.group_id
+				and member.account_id = instance_assignment.account_id
+			where account.id = $2 and account.role = $3
+			and (instance_assignment.group_id = teacher.group_id or member.account_id is not null)
+		)`, instanceId, accountId, ROLE_TEACHER).Scan(&teacher)
+	if err != nil {
+		return false, fmt.Errorf("check whether account %d teaches instance %s: %v", accountId, instanceId, err)
+	}
+	return teacher, nil
+}
+
+// LoadTaughtInstances returns the IDs of the instances assigned to the groups
+// of the teacher or to their members.
+func LoadTaughtInstances(ctx context.Context, pool *pgxpool.Pool, teacherId int) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`select distinct instance_assignment.instance_id from account
+		inner join group_member teacher on teacher.account_id = account.id
+		left join group_member member on member.group_id = teacher.group_id
+		inner join instance_assignment on instance_assignment.group_id = teacher.group_id
+			or instance_assignment.account_id = member.account_id
+		where account.id = $1 and account.role = $2`, teacherId, ROLE_TEACHER)
+	if err != nil {
+		return nil, fmt.Errorf("load instances taught by account %d: %v", teacherId, err)
+	}
+	defer rows.Close()
+	instances := make([]string, 0)
+	for rows.Next() {
+		var instanceId string
+		if err := rows.Scan(&instanceId); err != nil {
+			return nil, fmt.Errorf("scan taught instance: %v", err)
+		}
+		instances = append(instances, instanceId)
+	}
+	return instances, rows.Err()
+}
+
+// LoadGroupInstances returns the IDs of the instances assigned to the group
+// or to its members, mapped to the zones they were assigned in.
+func LoadGroupInstances(ctx context.Context, pool *pgxpool.Pool, groupId int) (map[string]string, error) {
+	rows, err := pool.Query(ctx,
+		`select distinct instance_assignment.instance_id, instance_assignment.zone from instance_assignment
+		left join group_member on group_member.group_id = $1
+			and group_member.account_id = instance_assignment.account_id
+		where instance_assignment.group_id = $1 or group_member.account_id is not null`, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("load instances of group %d: %v", groupId, err)
+	}
+	defer rows.Close()
+	instances := make(map[string]string)
+	for rows.Next() {
+		var instanceId, zone string
+		if err := rows.Scan(&instanceId, &zone); err != nil {
+			return nil, fmt.Errorf("scan group instance: %v", err)
+		}
+		instances[instanceId] = zone
+	}
+	return instances, rows.Err()
+}
+
+// LoadInstanceZone returns the zone the instance was assigned in, or an empty
+// string if the instance is not assigned.
+func LoadInstanceZone(ctx context.Context, pool *pgxpool.Pool, instanceId string) (string, error) {
+	var zone string
+	err := pool.QueryRow(ctx, "select zone from instance_assignment where instance_id = $1 limit 1", instanceId).
+		Scan(&zone)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("load zone of instance %s: %v", instanceId, err)
+	}
+	return zone, nil
+}
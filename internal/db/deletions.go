@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// maxDeletionAttempts limits how often the destruction of an instance is
+// retried before the pending deletion is left for an operator to look at.
+const maxDeletionAttempts = 5
+
+// Deletion is a pending deletion of an instance, which is carried out once it
+// is due, unless it is cancelled before.
+type Deletion struct {
+	Id         int       `json:"-"`
+	InstanceId string    `json:"instance_id"`
+	Zone       string    `json:"zone"`
+	Tenant     string    `json:"-"`
+	AccountId  int       `json:"-"`
+	Requested  time.Time `json:"requested"`
+	Due        time.Time `json:"due"`
+}
+
+func InsertDeletion(ctx context.Context, pool *pgxpool.Pool, d *Deletion) error {
+	err := pool.QueryRow(ctx,
+		`insert into instance_deletion (instance_id, zone, tenant, account_id, due)
+		values ($1, $2, $3, $4, $5) returning id, requested`,
+		d.InstanceId, d.Zone, d.Tenant, d.AccountId, d.Due).Scan(&d.Id, &d.Requested)
+	if err != nil {
+		return fmt.Errorf("insert deletion of instance %s: %v", d.InstanceId, err)
+	}
+	return nil
+}
+
+// LoadDeletion returns the pending deletion of the instance, or nil if there
+// is none.
+func LoadDeletion(ctx context.Context, pool *pgxpool.Pool, instanceId string) (*Deletion, error) {
+	d := Deletion{InstanceId: instanceId}
+	err := pool.QueryRow(ctx,
+		"select id, zone, tenant, account_id, requested, due from instance_deletion where instance_id = $1",
+		instanceId).Scan(&d.Id, &d.Zone, &d.Tenant, &d.AccountId, &d.Requested, &d.Due)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load deletion of instance %s: %v", instanceId, err)
+	}
+	return &d, nil
+}
+
+// ClaimDueDeletions returns the deletions that are due and have not failed too
+// often yet, and claims them for the given lease, so that other replicas of
+// the backend leave them alone. Claims of replicas that failed to finish or
+// release them expire with the lease.
+func ClaimDueDeletions(ctx context.Context, pool *pgxpool.Pool, lease time.Duration) ([]*Deletion, error) {
+	rows, err := pool.Query(ctx,
+		`update instance_deletion set claimed = now()
+		where id in (
+			select id from instance_deletion
+			where due <= now() and attempts < $1 and (claimed is null or claimed < $2)
+			for update skip locked
+		)
+		returning id, instance_id, zone, tenant, account_id, requested, due`,
+		maxDeletionAttempts, time.Now().Add(-lease))
+	if err != nil {
+		return nil, fmt.Errorf("claim due deletions: %v", err)
+	}
+	defer rows.Close()
+	deletions := make([]*Deletion, 0)
+	for rows.Next() {
+		var d Deletion
+		err := rows.Scan(&d.Id, &d.InstanceId, &d.Zone, &d.Tenant, &d.AccountId, &d.Requested, &d.Due)
+		if err != nil {
+			return nil, fmt.Errorf("scan deletion: %v", err)
+		}
+		deletions = append(deletions, &d)
+	}
+	return deletions, rows.Err()
+}
+
+// ReleaseDeletion gives up the claim of the deletion, so that it is retried.
+func ReleaseDeletion(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	if _, err := pool.Exec(ctx, "update instance_deletion set claimed = null where id = $1", id); err != nil {
+		return fmt.Errorf("release deletion %d: %v", id, err)
+	}
+	return nil
+}
+
+func RecordDeletionFailure(ctx context.Context, pool *pgxpool.Pool, id int, failure error) error {
+	_, err := pool.Exec(ctx,
+		"update instance_deletion set attempts = attempts + 1, last_error = $1, claimed = null where id = $2",
+		failure.Error(), id)
+	if err != nil {
+		return fmt.Errorf("record failure of deletion %d: %v", id, err)
+	}
+	return nil
+}
+
+// CancelDeletion removes the pending deletion of the instance and reports
+// whether there was one.
+func CancelDeletion(ctx context.Context, pool *pgxpool.Pool, instanceId string) (bool, error) {
+	tag, err := pool.Exec(ctx, "delete from instance_deletion where instance_id = $1", instanceId)
+	if err != nil {
+		return false, fmt.Errorf("cancel deletion of instance %s: %v", instanceId, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CompleteDeletion removes the pending deletion as well as all assignments,
+// ingress rules and snapshot records of the destroyed instance, whose
+// snapshots are gone with it.
+func CompleteDeletion(ctx context.Context, pool *pgxpool.Pool, d *Deletion) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "delete from instance_assignment where instance_id = $1", d.InstanceId); err != nil {
+		return fmt.Errorf("delete assignments of instance %s: %v", d.InstanceId, err)
+	}
+	if _, err := tx.Exec(ctx, "delete from ingress_rule where instance_id = $1", d.InstanceId); err != nil {
+		return fmt.Errorf("delete ingress rules of instance %s: %v", d.InstanceId, err)
+	}
+	if _, err := tx.Exec(ctx, "delete from instance_snapshot where instance_id = $1", d.InstanceId); err != nil {
+		return fmt.Errorf("delete snapshots of instance %s: %v", d.InstanceId, err)
+	}
+	if _, err := tx.Exec(ctx, "delete from instance_deletion where id = $1", d.Id); err != nil {
+		return fmt.Errorf("delete deletion %d: %v", d.Id, err)
+	}
+	return tx.Commit(ctx)
+}
@@ -12,14 +12,40 @@ import (
 type Kind string
 
 const (
-	ACCOUNT_CREATED    Kind = "account_created"
-	ACCOUNT_DELETED    Kind = "account_deleted"
-	LOGIN_SUCCESS      Kind = "login_success"
-	LOGIN_FAILURE      Kind = "login_failure"
-	INSTANCE_START     Kind = "instance_start"
-	INSTANCE_STOP      Kind = "instance_stop"
-	PASSWORD_REQUESTED Kind = "password_requested"
-	PASSWORD_RESET     Kind = "password_reset"
+	ACCOUNT_CREATED     Kind = "account_created"
+	ACCOUNT_DELETED     Kind = "account_deleted"
+	LOGIN_SUCCESS       Kind = "login_success"
+	LOGIN_FAILURE       Kind = "login_failure"
+	INSTANCE_START      Kind = "instance_start"
+	INSTANCE_STOP       Kind = "instance_stop"
+	INSTANCE_REBOOT     Kind = "instance_reboot"
+	INSTANCE_FORCE_STOP Kind = "instance_force_stop"
+	INSTANCE_CREATED    Kind = "instance_created"
+	INSTANCE_DELETING   Kind = "instance_deleting"
+	INSTANCE_RESTORED   Kind = "instance_restored"
+	INSTANCE_DELETED    Kind = "instance_deleted"
+	INSTANCE_RESET      Kind = "instance_reset"
+	INSTANCE_CONSOLE    Kind = "instance_console"
+	INSTANCE_PASSWORD   Kind = "instance_password"
+	PASSWORD_REVEALED   Kind = "password_revealed"
+	INSTANCE_RESIZED    Kind = "instance_resized"
+	RESIZE_REQUESTED    Kind = "resize_requested"
+	RESIZE_DECLINED     Kind = "resize_declined"
+	RULE_REQUESTED      Kind = "rule_requested"
+	RULE_APPLIED        Kind = "rule_applied"
+	RULE_DECLINED       Kind = "rule_declined"
+	RULE_REMOVED        Kind = "rule_removed"
+	INSTANCE_SNAPSHOT   Kind = "instance_snapshot"
+	SNAPSHOT_REVERTED   Kind = "snapshot_reverted"
+	SNAPSHOT_DELETED    Kind = "snapshot_deleted"
+	OFFERING_CREATED    Kind = "offering_created"
+	OFFERING_DELETED    Kind = "offering_deleted"
+	PASSWORD_REQUESTED  Kind = "password_requested"
+	PASSWORD_RESET      Kind = "password_reset"
+	LOGIN_LINK_SENT     Kind = "login_link_sent"
+	LOGIN_LINK_USED     Kind = "login_link_used"
+	SSH_KEY_ADDED       Kind = "ssh_key_added"
+	SSH_KEY_DELETED     Kind = "ssh_key_deleted"
 )
 
 func LogEvent(ctx context.Context, pool *pgxpool.Pool, kind Kind, accountId int, infoKey, infoVal string) {
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Group struct {
+	Id           int
+	Name         string
+	Tenant       string
+	MaxInstances int
+}
+
+// EnsureGroup returns the id of the group with the given name within the
+// tenant, creating the group if it does not exist yet.
+func EnsureGroup(ctx context.Context, pool *pgxpool.Pool, name, tenant string) (int, error) {
+	var id int
+	err := pool.QueryRow(ctx,
+		`insert into account_group (name, tenant) values ($1, $2)
+		on conflict (name, tenant) do update set name = excluded.name
+		returning id`, name, tenant).Scan(&id)
+	if err != nil {
+		return -1, fmt.Errorf("ensure group '%s' of tenant '%s': %v", name, tenant, err)
+	}
+	return id, nil
+}
+
+func LoadGroupByName(ctx context.Context, pool *pgxpool.Pool, name, tenant string) (*Group, error) {
+	group := Group{Name: name, Tenant: tenant}
+	err := pool.QueryRow(ctx, "select id, max_instances from account_group where name = $1 and tenant = $2", name, tenant).
+		Scan(&group.Id, &group.MaxInstances)
+	if err != nil {
+		return nil, fmt.Errorf("load group '%s' of tenant '%s': %v", name, tenant, err)
+	}
+	return &group, nil
+}
+
+func AddGroupMember(ctx context.Context, pool *pgxpool.Pool, groupId, accountId int) error {
+	_, err := pool.Exec(ctx,
+		"insert into group_member (group_id, account_id) values ($1, $2) on conflict do nothing",
+		groupId, accountId)
+	if err != nil {
+		return fmt.Errorf("add account %d to group %d: %v", accountId, groupId, err)
+	}
+	return nil
+}
+
+func LoadGroupById(ctx context.Context, pool *pgxpool.Pool, id int) (*Group, error) {
+	group := Group{Id: id}
+	err := pool.QueryRow(ctx, "select name, tenant, max_instances from account_group where id = $1", id).
+		Scan(&group.Name, &group.Tenant, &group.MaxInstances)
+	if err != nil {
+		return nil, fmt.Errorf("load group %d: %v", id, err)
+	}
+	return &group, nil
+}
+
+func IsGroupMember(ctx context.Context, pool *pgxpool.Pool, groupId, accountId int) (bool, error) {
+	var member bool
+	err := pool.QueryRow(ctx,
+		"select exists (select 1 from group_member where group_id = $1 and account_id = $2)",
+		groupId, accountId).Scan(&member)
+	if err != nil {
+		return false, fmt.Errorf("check membership of account %d in group %d: %v", accountId, groupId, err)
+	}
+	return member, nil
+}
+
+func SetGroupMaxInstances(ctx context.Context, pool *pgxpool.Pool, groupId, maxInstances int) error {
+	_, err := pool.Exec(ctx, "update account_group set max_instances = $1 where id = $2", maxInstances, groupId)
+	if err != nil {
+		return fmt.Errorf("set max instances of group %d: %v", groupId, err)
+	}
+	return nil
+}
+
+func SetGroupMaxSnapshots(ctx context.Context, pool *pgxpool.Pool, groupId, maxSnapshots int) error {
+	_, err := pool.Exec(ctx, "update account_group set max_snapshots = $1 where id = $2", maxSnapshots, groupId)
+	if err != nil {
+		return fmt.Errorf("set max snapshots of group %d: %v", groupId, err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IdempotentRequest is a request made with an idempotency key, whose response
+// is replayed if the request is retried with the same key. Status is nil while
+// the request is being processed.
+type IdempotentRequest struct {
+	Fingerprint string
+	Status      *int
+	Location    *string
+	Body        []byte
+}
+
+// ClaimIdempotencyKey records the request with the key of the account, unless
+// the key was used before within the TTL. It returns nil if the key was
+// claimed, and the request made with the key before otherwise. Keys older than
+// the TTL, and keys of requests still not responded to after the processing
+// timeout, e.g. because the backend died, are claimed anew.
+func ClaimIdempotencyKey(ctx context.Context, pool *pgxpool.Pool, accountId int, key, fingerprint string, ttl, processingTimeout time.Duration) (*IdempotentRequest, error) {
+	now := time.Now()
+	tag, err := pool.Exec(ctx,
+		`insert into idempotency_key (account_id, key, fingerprint) values ($1, $2, $3)
+		on conflict (account_id, key) do update
+		set fingerprint = excluded.fingerprint, status = null, location = null, body = null, created = now()
+		where idempotency_key.created < $4 or (idempotency_key.status is null and idempotency_key.created < $5)`,
+		accountId, key, fingerprint, now.Add(-ttl), now.Add(-processingTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("claim idempotency key %s: %v", key, err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+	var i IdempotentRequest
+	err = pool.QueryRow(ctx,
+		"select fingerprint, status, location, body from idempotency_key where account_id = $1 and key = $2",
+		accountId, key).Scan(&i.Fingerprint, &i.Status, &i.Location, &i.Body)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// released in the meantime by a request that failed
+		return nil, fmt.Errorf("idempotency key %s released while claiming it", key)
+	} else if err != nil {
+		return nil, fmt.Errorf("load request with idempotency key %s: %v", key, err)
+	}
+	return &i, nil
+}
+
+// StoreIdempotentResponse records the response to the request made with the
+// key of the account, to be replayed for retries.
+func StoreIdempotentResponse(ctx context.Context, pool *pgxpool.Pool, accountId int, key string, status int, location *string, body []byte) error {
+	_, err := pool.Exec(ctx,
+		"update idempotency_key set status = $1, location = $2, body = $3 where account_id = $4 and key = $5",
+		status, location, body, accountId, key)
+	if err != nil {
+		return fmt.Errorf("store response for idempotency key %s: %v", key, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey discards the key of the account, so that the request
+// made with it can be repeated.
+func ReleaseIdempotencyKey(ctx context.Context, pool *pgxpool.Pool, accountId int, key string) error {
+	_, err := pool.Exec(ctx, "delete from idempotency_key where account_id = $1 and key = $2", accountId, key)
+	if err != nil {
+		return fmt.Errorf("release idempotency key %s: %v", key, err)
+	}
+	return nil
+}
+
+// DiscardExpiredIdempotencyKeys removes the keys older than the TTL.
+func DiscardExpiredIdempotencyKeys(ctx context.Context, pool *pgxpool.Pool, ttl time.Duration) error {
+	if _, err := pool.Exec(ctx, "delete from idempotency_key where created < $1", time.Now().Add(-ttl)); err != nil {
+		return fmt.Errorf("discard expired idempotency keys: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// instanceLockClass sets the advisory locks of instances apart from other
+// advisory locks, being the first of the two keys identifying them.
+const instanceLockClass = 0x436c4361
+
+// accountLockClass is the first key of the advisory locks of accounts.
+const accountLockClass = 0x436c4163
+
+// TryLockInstance takes the advisory lock of the instance, which is shared by
+// all replicas of the backend, and returns the function releasing it, or nil
+// if the lock is held already. The lock is bound to a connection of its own,
+// so that long actions do not use up the connections of the pool; closing it
+// releases the lock even if the backend fails to.
+func TryLockInstance(ctx context.Context, pool *pgxpool.Pool, instanceId string) (func(), error) {
+	conn, err := pgx.ConnectConfig(ctx, pool.Config().ConnConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connect to lock instance %s: %v", instanceId, err)
+	}
+	var locked bool
+	err = conn.QueryRow(ctx, "select pg_try_advisory_lock($1, hashtext($2))",
+		instanceLockClass, instanceId).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("lock instance %s: %v", instanceId, err)
+		}
+		return nil, nil
+	}
+	return func() { conn.Close(context.Background()) }, nil
+}
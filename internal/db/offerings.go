@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Offering describes an instance that members of a group may create on their
+// own, as curated by the group's teachers.
+type Offering struct {
+	Id             int      `json:"id"`
+	GroupId        int      `json:"group_id"`
+	Name           string   `json:"name"`
+	TemplateId     string   `json:"template_id"`
+	InstanceType   string   `json:"instance_type"`
+	DiskSize       int      `json:"disk_size"`
+	SecurityGroups []string `json:"security_groups"`
+	UserData       string   `json:"user_data,omitempty"`
+	AllowDelete    bool     `json:"allow_delete"`
+}
+
+const offeringColumns = "id, group_id, name, template_id, instance_type, disk_size, security_groups, coalesce(user_data, ''), allow_delete"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOffering(row scanner) (*Offering, error) {
+	var o Offering
+	err := row.Scan(&o.Id, &o.GroupId, &o.Name, &o.TemplateId, &o.InstanceType, &o.DiskSize, &o.SecurityGroups, &o.UserData, &o.AllowDelete)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func InsertOffering(ctx context.Context, pool *pgxpool.Pool, o *Offering) (int, error) {
+	var id int
+	if o.SecurityGroups == nil {
+		o.SecurityGroups = []string{}
+	}
+	err := pool.QueryRow(ctx,
+		`insert into offering (group_id, name, template_id, instance_type, disk_size, security_groups, user_data, allow_delete)
+		values ($1, $2, $3, $4, $5, $6, nullif($7, ''), $8) returning id`,
+		o.GroupId, o.Name, o.TemplateId, o.InstanceType, o.DiskSize, o.SecurityGroups, o.UserData, o.AllowDelete).Scan(&id)
+	if err != nil {
+		return -1, fmt.Errorf("insert offering '%s': %v", o.Name, err)
+	}
+	return id, nil
+}
+
+func LoadOffering(ctx context.Context, pool *pgxpool.Pool, id int) (*Offering, error) {
+	o, err := scanOffering(pool.QueryRow(ctx, "select "+offeringColumns+" from offering where id = $1", id))
+	if err != nil {
+		return nil, fmt.Errorf("load offering %d: %v", id, err)
+	}
+	return o, nil
+}
+
+// LoadOfferingsForAccount returns the offerings of all groups the account is
+// a member of.
+func LoadOfferingsForAccount(ctx context.Context, pool *pgxpool.Pool, accountId int) ([]*Offering, error) {
+	rows, err := pool.Query(ctx,
+		`select `+offeringColumns+` from offering
+		where group_id in (select group_id from group_member where account_id = $1)
+		order by group_id, name`, accountId)
+	if err != nil {
+		return nil, fmt.Errorf("load offerings for account %d: %v", accountId, err)
+	}
+	defer rows.Close()
+	offerings := make([]*Offering, 0)
+	for rows.Next() {
+		o, err := scanOffering(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan offering: %v", err)
+		}
+		offerings = append(offerings, o)
+	}
+	return offerings, rows.Err()
+}
+
+func DeleteOffering(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	if _, err := pool.Exec(ctx, "delete from offering where id = $1", id); err != nil {
+		return fmt.Errorf("delete offering %d: %v", id, err)
+	}
+	return nil
+}
+
+// LoadInstanceOffering returns the offering the instance was created from, or
+// nil if it was not created from an (existing) offering.
+func LoadInstanceOffering(ctx context.Context, pool *pgxpool.Pool, instanceId string) (*Offering, error) {
+	o, err := scanOffering(pool.QueryRow(ctx,
+		`select `+offeringColumns+` from offering
+		where id = (select offering_id from instance_assignment where instance_id = $1 and offering_id is not null limit 1)`,
+		instanceId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load offering of instance %s: %v", instanceId, err)
+	}
+	return o, nil
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type OperationState string
+
+const (
+	OPERATION_PENDING OperationState = "pending"
+	OPERATION_SUCCESS OperationState = "success"
+	OPERATION_FAILURE OperationState = "failure"
+)
+
+// Operation is an action on an instance that Exoscale carries out in the
+// background, which is tracked until it succeeds or fails.
+type Operation struct {
+	Id         int    `json:"id"`
+	AccountId  int    `json:"-"`
+	InstanceId string `json:"instance_id"`
+	Zone       string `json:"-"`
+	Tenant     string `json:"-"`
+	Kind       Kind   `json:"kind"`
+	// ProviderId is the ID by which the cloud provider tracks the operation.
+	ProviderId string         `json:"-"`
+	State      OperationState `json:"state"`
+	Error      string         `json:"error,omitempty"`
+	Created    time.Time      `json:"created"`
+	Finished   *time.Time     `json:"finished,omitempty"`
+}
+
+const operationColumns = `id, account_id, instance_id, zone, tenant, kind, provider_id, state,
+	coalesce(error, ''), created, finished`
+
+func scanOperation(row scanner) (*Operation, error) {
+	var o Operation
+	err := row.Scan(&o.Id, &o.AccountId, &o.InstanceId, &o.Zone, &o.Tenant, &o.Kind, &o.ProviderId, &o.State,
+		&o.Error, &o.Created, &o.Finished)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func InsertOperation(ctx context.Context, pool *pgxpool.Pool, o *Operation) error {
+	err := pool.QueryRow(ctx,
+		`insert into operation (account_id, instance_id, zone, tenant, kind, provider_id, claimed)
+		values ($1, $2, $3, $4, $5, $6, now()) returning id, state, created`,
+		o.AccountId, o.InstanceId, o.Zone, o.Tenant, o.Kind, o.ProviderId).Scan(&o.Id, &o.State, &o.Created)
+	if err != nil {
+		return fmt.Errorf("insert operation on instance %s: %v", o.InstanceId, err)
+	}
+	return nil
+}
+
+// LoadOperation returns the operation with the given id, or nil if there is
+// none.
+func LoadOperation(ctx context.Context, pool *pgxpool.Pool, id int) (*Operation, error) {
+	o, err := scanOperation(pool.QueryRow(ctx, "select "+operationColumns+" from operation where id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load operation %d: %v", id, err)
+	}
+	return o, nil
+}
+
+// ClaimPendingOperations returns the operations that have not finished yet and
+// are not tracked anymore, e.g. because the server was restarted while tracking
+// them, and claims them for the given lease, so that other replicas of the
+// backend leave them alone. Operations are claimed by the replica inserting
+// them.
+func ClaimPendingOperations(ctx context.Context, pool *pgxpool.Pool, lease time.Duration) ([]*Operation, error) {
+	rows, err := pool.Query(ctx,
+		`update operation set claimed = now()
+		where id in (
+			select id from operation
+			where state = $1 and (claimed is null or claimed < $2)
+			for update skip locked
+		)
+		returning `+operationColumns,
+		OPERATION_PENDING, time.Now().Add(-lease))
+	if err != nil {
+		return nil, fmt.Errorf("claim pending operations: %v", err)
+	}
+	defer rows.Close()
+	operations := make([]*Operation, 0)
+	for rows.Next() {
+		o, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan operation: %v", err)
+		}
+		operations = append(operations, o)
+	}
+	return operations, rows.Err()
+}
+
+// ReleaseOperation gives up the claim of the pending operation, so that
+// another replica resumes tracking it.
+func ReleaseOperation(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	_, err := pool.Exec(ctx, "update operation set claimed = null where id = $1 and state = $2", id, OPERATION_PENDING)
+	if err != nil {
+		return fmt.Errorf("release operation %d: %v", id, err)
+	}
+	return nil
+}
+
+// LoadPendingOperation returns the latest operation on the instance that has
+// not finished yet, or nil if there is none.
+func LoadPendingOperation(ctx context.Context, pool *pgxpool.Pool, instanceId string) (*Operation, error) {
+	o, err := scanOperation(pool.QueryRow(ctx,
+		"select "+operationColumns+" from operation where instance_id = $1 and state = $2 order by created desc limit 1",
+		instanceId, OPERATION_PENDING))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load pending operation on instance %s: %v", instanceId, err)
+	}
+	return o, nil
+}
+
+// FinishOperation records the outcome of the operation, which failed if the
+// given error is not nil. Operations finished already are left as they are.
+func FinishOperation(ctx context.Context, pool *pgxpool.Pool, id int, failure error) error {
+	state, message := OPERATION_SUCCESS, (*string)(nil)
+	if failure != nil {
+		text := failure.Error()
+		state, message = OPERATION_FAILURE, &text
+	}
+	_, err := pool.Exec(ctx,
+		"update operation set state = $1, error = $2, finished = now() where id = $3 and state = $4",
+		state, message, id, OPERATION_PENDING)
+	if err != nil {
+		return fmt.Errorf("finish operation %d: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// InsertPasswordReset records that the instance's password has been reset and
+// can be revealed once.
+func InsertPasswordReset(ctx context.Context, pool *pgxpool.Pool, instanceId string, accountId int) error {
+	_, err := pool.Exec(ctx,
+		`insert into instance_password (instance_id, reset_by) values ($1, $2)
+		on conflict (instance_id) do update set reset_by = excluded.reset_by, reset = now(),
+			revealed_by = null, revealed = null`,
+		instanceId, accountId)
+	if err != nil {
+		return fmt.Errorf("insert password reset of instance %s: %v", instanceId, err)
+	}
+	return nil
+}
+
+// ClaimPasswordReveal marks the instance's password as revealed to the account
+// and reports whether it had not been revealed before.
+func ClaimPasswordReveal(ctx context.Context, pool *pgxpool.Pool, instanceId string, accountId int) (bool, error) {
+	tag, err := pool.Exec(ctx,
+		`update instance_password set revealed_by = $2, revealed = now()
+		where instance_id = $1 and revealed is null`,
+		instanceId, accountId)
+	if err != nil {
+		return false, fmt.Errorf("claim password reveal of instance %s: %v", instanceId, err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleasePasswordReveal undoes ClaimPasswordReveal if the password could not
+// be revealed after all.
+func ReleasePasswordReveal(ctx context.Context, pool *pgxpool.Pool, instanceId string) error {
+	_, err := pool.Exec(ctx,
+		"update instance_password set revealed_by = null, revealed = null where instance_id = $1",
+		instanceId)
+	if err != nil {
+		return fmt.Errorf("release password reveal of instance %s: %v", instanceId, err)
+	}
+	return nil
+}
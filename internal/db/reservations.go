@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// reservationLease is how long a reservation counts against the limit of an
+// account, after which the replica holding it is assumed to have failed to
+// release it.
+const reservationLease = 15 * time.Minute
+
+// ReserveInstance reserves one of the instances the account may own at most,
+// unless it owns or reserved as many already. It returns the ID of the
+// reservation, or 0 if the limit is reached. The reservation has to be
+// released once the instance is assigned or failed to be created.
+func ReserveInstance(ctx context.Context, pool *pgxpool.Pool, accountId, limit int) (int, error) {
+	return reserve(ctx, pool, accountId, "instance", limit, func(tx pgx.Tx) (int, error) {
+		var count int
+		err := tx.QueryRow(ctx,
+			"select count(*) from instance_assignment where account_id = $1 and permission = $2",
+			accountId, PERMISSION_OWNER).Scan(&count)
+		return count, err
+	})
+}
+
+// ReserveSnapshot reserves one of the snapshots the account may keep at most,
+// like ReserveInstance.
+func ReserveSnapshot(ctx context.Context, pool *pgxpool.Pool, accountId, limit int) (int, error) {
+	return reserve(ctx, pool, accountId, "snapshot", limit, func(tx pgx.Tx) (int, error) {
+		var count int
+		err := tx.QueryRow(ctx, "select count(*) from instance_snapshot where account_id = $1", accountId).Scan(&count)
+		return count, err
+	})
+}
+
+// reserve counts the reservations of the kind held by the account, along with
+// what they are for, and reserves another one unless the limit is reached. The
+// transaction doing so is kept short, as the account is locked meanwhile, and
+// discards the reservations whose lease expired.
+func reserve(ctx context.Context, pool *pgxpool.Pool, accountId int, kind string, limit int, count func(pgx.Tx) (int, error)) (int, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction to reserve %s for account %d: %v", kind, accountId, err)
+	}
+	defer tx.Rollback(context.Background())
+	if _, err := tx.Exec(ctx, "select pg_advisory_xact_lock($1, $2)", accountLockClass, accountId); err != nil {
+		return 0, fmt.Errorf("lock account %d: %v", accountId, err)
+	}
+	existing, err := count(tx)
+	if err != nil {
+		return 0, fmt.Errorf("count %ss of account %d: %v", kind, accountId, err)
+	}
+	_, err = tx.Exec(ctx, "delete from account_reservation where account_id = $1 and created < $2",
+		accountId, time.Now().Add(-reservationLease))
+	if err != nil {
+		return 0, fmt.Errorf("discard expired reservations of account %d: %v", accountId, err)
+	}
+	var reserved int
+	err = tx.QueryRow(ctx,
+		"select count(*) from account_reservation where account_id = $1 and kind = $2",
+		accountId, kind).Scan(&reserved)
+	if err != nil {
+		return 0, fmt.Errorf("count %s reservations of account %d: %v", kind, accountId, err)
+	}
+	if existing+reserved >= limit {
+		return 0, nil
+	}
+	var id int
+	err = tx.QueryRow(ctx,
+		"insert into account_reservation (account_id, kind) values ($1, $2) returning id",
+		accountId, kind).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("reserve %s for account %d: %v", kind, accountId, err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit %s reservation of account %d: %v", kind, accountId, err)
+	}
+	return id, nil
+}
+
+// ReleaseReservation gives up the reservation.
+func ReleaseReservation(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	if _, err := pool.Exec(ctx, "delete from account_reservation where id = $1", id); err != nil {
+		return fmt.Errorf("release reservation %d: %v", id, err)
+	}
+	return nil
+}
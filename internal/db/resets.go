@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// InsertReset records that the account reinstalled the instance from the
+// template.
+func InsertReset(ctx context.Context, pool *pgxpool.Pool, instanceId string, accountId int, templateId string) error {
+	_, err := pool.Exec(ctx,
+		"insert into instance_reset (instance_id, account_id, template_id) values ($1, $2, $3)",
+		instanceId, accountId, templateId)
+	if err != nil {
+		return fmt.Errorf("insert reset of instance %s: %v", instanceId, err)
+	}
+	return nil
+}
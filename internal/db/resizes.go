@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RequestStatus is the state of a request that a teacher has to decide.
+type RequestStatus string
+
+const (
+	REQUEST_PENDING  RequestStatus = "pending"
+	REQUEST_APPROVED RequestStatus = "approved"
+	REQUEST_DENIED   RequestStatus = "denied"
+)
+
+// AllowedInstanceType is an instance type the members of a group may resize
+// their instances to, possibly only with the approval of a teacher.
+type AllowedInstanceType struct {
+	GroupId          int    `json:"group_id"`
+	InstanceType     string `json:"instance_type"`
+	RequiresApproval bool   `json:"requires_approval"`
+}
+
+// Resize is a change of an instance's type, which is either pending the
+// approval of a teacher or has been decided.
+type Resize struct {
+	Id         int           `json:"id"`
+	InstanceId string        `json:"instance_id"`
+	AccountId  int           `json:"account_id"`
+	OldType    string        `json:"old_type"`
+	NewType    string        `json:"new_type"`
+	Requested  time.Time     `json:"requested"`
+	Status     RequestStatus `json:"status"`
+}
+
+func AllowInstanceType(ctx context.Context, pool *pgxpool.Pool, t *AllowedInstanceType) error {
+	_, err := pool.Exec(ctx,
+		`insert into group_instance_type (group_id, instance_type, requires_approval) values ($1, $2, $3)
+		on conflict (group_id, instance_type) do update set requires_approval = excluded.requires_approval`,
+		t.GroupId, t.InstanceType, t.RequiresApproval)
+	if err != nil {
+		return fmt.Errorf("allow instance type %s for group %d: %v", t.InstanceType, t.GroupId, err)
+	}
+	return nil
+}
+
+// DisallowInstanceType returns true if the instance type was allowed for the
+// group before.
+func DisallowInstanceType(ctx context.Context, pool *pgxpool.Pool, groupId int, instanceType string) (bool, error) {
+	tag, err := pool.Exec(ctx,
+		"delete from group_instance_type where group_id = $1 and instance_type = $2", groupId, instanceType)
+	if err != nil {
+		return false, fmt.Errorf("disallow instance type %s for group %d: %v", instanceType, groupId, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func LoadAllowedInstanceTypes(ctx context.Context, pool *pgxpool.Pool, groupId int) ([]*AllowedInstanceType, error) {
+	rows, err := pool.Query(ctx,
+		`select instance_type, requires_approval from group_instance_type
+		where group_id = $1 order by instance_type`, groupId)
+	if err != nil {
+		return nil, fmt.Errorf("load instance types of group %d: %v", groupId, err)
+	}
+	defer rows.Close()
+	types := make([]*AllowedInstanceType, 0)
+	for rows.Next() {
+		t := AllowedInstanceType{GroupId: groupId}
+		if err := rows.Scan(&t.InstanceType, &t.RequiresApproval); err != nil {
+			return nil, fmt.Errorf("scan instance type: %v", err)
+		}
+		types = append(types, &t)
+	}
+	return types, rows.Err()
+}
+
+// CheckInstanceType reports whether one of the account's groups allows the
+// instance type, and whether resizing to it requires approval. Approval is
+// only required if all groups allowing the type require it.
+func CheckInstanceType(ctx context.Context, pool *pgxpool.Pool, accountId int, instanceType string) (bool, bool, error) {
+	var requiresApproval *bool
+	err := pool.QueryRow(ctx,
+		`select bool_and(requires_approval) from group_instance_type
+		inner join group_member on group_member.group_id = group_instance_type.group_id
+		where group_member.account_id = $1 and instance_type = $2`,
+		accountId, instanceType).Scan(&requiresApproval)
+	if err != nil {
+		return false, false, fmt.Errorf("check instance type %s for account %d: %v", instanceType, accountId, err)
+	}
+	if requiresApproval == nil {
+		return false, false, nil
+	}
+	return true, *requiresApproval, nil
+}
+
+// InsertResize records the resize and returns false if another resize of the
+// instance is already pending.
+func InsertResize(ctx context.Context, pool *pgxpool.Pool, r *Resize) (bool, error) {
+	var decidedBy *int
+	if r.Status != REQUEST_PENDING {
+		decidedBy = &r.AccountId
+	}
+	err := pool.QueryRow(ctx,
+		`insert into instance_resize (instance_id, account_id, old_type, new_type, status, decided_by, decided)
+		values ($1, $2, $3, $4, $5, $6, case when $5 = 'pending' then null else now() end)
+		on conflict (instance_id) where status = 'pending' do nothing
+		returning id, requested`,
+		r.InstanceId, r.AccountId, r.OldType, r.NewType, r.Status, decidedBy).Scan(&r.Id, &r.Requested)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("insert resize of instance %s: %v", r.InstanceId, err)
+	}
+	return true, nil
+}
+
+// LoadPendingResize returns the pending resize with the given id, or nil if
+// there is none.
+func LoadPendingResize(ctx context.Context, pool *pgxpool.Pool, id int) (*Resize, error) {
+	r := Resize{Id: id}
+	err := pool.QueryRow(ctx,
+		`select instance_id, account_id, old_type, new_type, requested, status from instance_resize
+		where id = $1 and status = $2`, id, REQUEST_PENDING).
+		Scan(&r.InstanceId, &r.AccountId, &r.OldType, &r.NewType, &r.Requested, &r.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load resize %d: %v", id, err)
+	}
+	return &r, nil
+}
+
+// LoadPendingResizesForTeacher returns the pending resizes requested by
+// members of the teacher's groups, oldest first.
+func LoadPendingResizesForTeacher(ctx context.Context, pool *pgxpool.Pool, teacherId int) ([]*Resize, error) {
+	rows, err := pool.Query(ctx,
+		`select distinct instance_resize.id, instance_id, instance_resize.account_id, old_type, new_type, requested, status
+		from instance_resize
+		inner join group_member member on member.account_id = instance_resize.account_id
+		inner join group_member teacher on teacher.group_id = member.group_id
+		where teacher.account_id = $1 and status = $2
+		order by requested`, teacherId, REQUEST_PENDING)
+	if err != nil {
+		return nil, fmt.Errorf("load pending resizes for teacher %d: %v", teacherId, err)
+	}
+	defer rows.Close()
+	resizes := make([]*Resize, 0)
+	for rows.Next() {
+		var r Resize
+		err := rows.Scan(&r.Id, &r.InstanceId, &r.AccountId, &r.OldType, &r.NewType, &r.Requested, &r.Status)
+		if err != nil {
+			return nil, fmt.Errorf("scan resize: %v", err)
+		}
+		resizes = append(resizes, &r)
+	}
+	return resizes, rows.Err()
+}
+
+// DecideResize approves or denies the pending resize and returns false if it
+// was not pending anymore.
+func DecideResize(ctx context.Context, pool *pgxpool.Pool, id int, status RequestStatus, teacherId int) (bool, error) {
+	tag, err := pool.Exec(ctx,
+		`update instance_resize set status = $1, decided_by = $2, decided = now()
+		where id = $3 and status = $4`, status, teacherId, id, REQUEST_PENDING)
+	if err != nil {
+		return false, fmt.Errorf("decide resize %d: %v", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ReopenResize makes the approved resize pending again, e.g. because the
+// instance could not be scaled.
+func ReopenResize(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	_, err := pool.Exec(ctx,
+		`update instance_resize set status = $1, decided_by = null, decided = null
+		where id = $2 and status = $3`, REQUEST_PENDING, id, REQUEST_APPROVED)
+	if err != nil {
+		return fmt.Errorf("reopen resize %d: %v", id, err)
+	}
+	return nil
+}
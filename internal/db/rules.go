@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IngressRule is a requested opening of a port on an instance, which is
+// applied to the instance's own security group once a teacher approves it.
+type IngressRule struct {
+	Id              int           `json:"id"`
+	InstanceId      string        `json:"instance_id"`
+	AccountId       int           `json:"account_id"`
+	Protocol        string        `json:"protocol"`
+	Port            int           `json:"port"`
+	Network         string        `json:"network"`
+	Requested       time.Time     `json:"requested"`
+	Status          RequestStatus `json:"status"`
+	SecurityGroupId string        `json:"-"`
+	RuleId          string        `json:"-"`
+}
+
+const ingressRuleColumns = `id, instance_id, account_id, protocol, port, network, requested, status,
+	coalesce(security_group_id, ''), coalesce(rule_id, '')`
+
+func scanIngressRule(row scanner) (*IngressRule, error) {
+	var r IngressRule
+	err := row.Scan(&r.Id, &r.InstanceId, &r.AccountId, &r.Protocol, &r.Port, &r.Network, &r.Requested, &r.Status,
+		&r.SecurityGroupId, &r.RuleId)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func InsertIngressRule(ctx context.Context, pool *pgxpool.Pool, r *IngressRule) error {
+	err := pool.QueryRow(ctx,
+		`insert into ingress_rule (instance_id, account_id, protocol, port, network)
+		values ($1, $2, $3, $4, $5) returning id, requested, status`,
+		r.InstanceId, r.AccountId, r.Protocol, r.Port, r.Network).Scan(&r.Id, &r.Requested, &r.Status)
+	if err != nil {
+		return fmt.Errorf("insert ingress rule for instance %s: %v", r.InstanceId, err)
+	}
+	return nil
+}
+
+// LoadIngressRule returns the rule with the given id, or nil if there is none.
+func LoadIngressRule(ctx context.Context, pool *pgxpool.Pool, id int) (*IngressRule, error) {
+	row := pool.QueryRow(ctx, "select "+ingressRuleColumns+" from ingress_rule where id = $1", id)
+	r, err := scanIngressRule(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load ingress rule %d: %v", id, err)
+	}
+	return r, nil
+}
+
+func LoadIngressRules(ctx context.Context, pool *pgxpool.Pool, instanceId string) ([]*IngressRule, error) {
+	return queryIngressRules(ctx, pool,
+		"select "+ingressRuleColumns+" from ingress_rule where instance_id = $1 order by port, protocol",
+		instanceId)
+}
+
+// LoadPendingIngressRulesForTeacher returns the pending rules requested by
+// members of the teacher's groups, oldest first.
+func LoadPendingIngressRulesForTeacher(ctx context.Context, pool *pgxpool.Pool, teacherId int) ([]*IngressRule, error) {
+	return queryIngressRules(ctx, pool,
+		`select `+ingressRuleColumns+` from ingress_rule
+		where status = $2 and account_id in (
+			select member.account_id from group_member member
+			inner join group_member teacher on teacher.group_id = member.group_id
+			where teacher.account_id = $1
+		)
+		order by requested`, teacherId, REQUEST_PENDING)
+}
+
+func queryIngressRules(ctx context.Context, pool *pgxpool.Pool, query string, args ...any) ([]*IngressRule, error) {
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("load ingress rules: %v", err)
+	}
+	defer rows.Close()
+	rules := make([]*IngressRule, 0)
+	for rows.Next() {
+		r, err := scanIngressRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan ingress rule: %v", err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// ApproveIngressRule records that the pending rule has been applied as the
+// given rule of the security group, and returns false if it was not pending
+// anymore.
+func ApproveIngressRule(ctx context.Context, pool *pgxpool.Pool, id, teacherId int, securityGroupId, ruleId string) (bool, error) {
+	tag, err := pool.Exec(ctx,
+		`update ingress_rule set status = $1, decided_by = $2, decided = now(), security_group_id = $3, rule_id = $4
+		where id = $5 and status = $6`,
+		REQUEST_APPROVED, teacherId, securityGroupId, ruleId, id, REQUEST_PENDING)
+	if err != nil {
+		return false, fmt.Errorf("approve ingress rule %d: %v", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DenyIngressRule returns false if the rule was not pending anymore.
+func DenyIngressRule(ctx context.Context, pool *pgxpool.Pool, id, teacherId int) (bool, error) {
+	tag, err := pool.Exec(ctx,
+		`update ingress_rule set status = $1, decided_by = $2, decided = now()
+		where id = $3 and status = $4`,
+		REQUEST_DENIED, teacherId, id, REQUEST_PENDING)
+	if err != nil {
+		return false, fmt.Errorf("deny ingress rule %d: %v", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func DeleteIngressRule(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	if _, err := pool.Exec(ctx, "delete from ingress_rule where id = $1", id); err != nil {
+		return fmt.Errorf("delete ingress rule %d: %v", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultMaxSnapshots is the number of snapshots an account may keep if it is
+// not a member of any group.
+const DefaultMaxSnapshots = 3
+
+// Snapshot records who took a snapshot of an instance and under which name.
+type Snapshot struct {
+	SnapshotId string
+	InstanceId string
+	AccountId  int
+	Name       string
+	Created    time.Time
+}
+
+func InsertSnapshot(ctx context.Context, pool *pgxpool.Pool, snapshot *Snapshot) error {
+	err := pool.QueryRow(ctx,
+		`insert into instance_snapshot (snapshot_id, instance_id, account_id, name)
+		values ($1, $2, $3, $4) returning created`,
+		snapshot.SnapshotId, snapshot.InstanceId, snapshot.AccountId, snapshot.Name).Scan(&snapshot.Created)
+	if err != nil {
+		return fmt.Errorf("insert snapshot %s of instance %s: %v", snapshot.SnapshotId, snapshot.InstanceId, err)
+	}
+	return nil
+}
+
+// LoadSnapshotNames returns the names given to the snapshots of the instance,
+// indexed by snapshot id.
+func LoadSnapshotNames(ctx context.Context, pool *pgxpool.Pool, instanceId string) (map[string]string, error) {
+	rows, err := pool.Query(ctx, "select snapshot_id, name from instance_snapshot where instance_id = $1", instanceId)
+	if err != nil {
+		return nil, fmt.Errorf("load snapshot names of instance %s: %v", instanceId, err)
+	}
+	defer rows.Close()
+	names := make(map[string]string)
+	for rows.Next() {
+		var snapshotId, name string
+		if err := rows.Scan(&snapshotId, &name); err != nil {
+			return nil, fmt.Errorf("scan snapshot name: %v", err)
+		}
+		names[snapshotId] = name
+	}
+	return names, rows.Err()
+}
+
+func DeleteSnapshot(ctx context.Context, pool *pgxpool.Pool, snapshotId string) error {
+	if _, err := pool.Exec(ctx, "delete from instance_snapshot where snapshot_id = $1", snapshotId); err != nil {
+		return fmt.Errorf("delete snapshot %s: %v", snapshotId, err)
+	}
+	return nil
+}
+
+// LoadMaxSnapshots returns the highest number of snapshots any of the
+// account's groups allows, or DefaultMaxSnapshots if it has no groups.
+func LoadMaxSnapshots(ctx context.Context, pool *pgxpool.Pool, accountId int) (int, error) {
+	var limit int
+	err := pool.QueryRow(ctx,
+		`select coalesce(max(max_snapshots), $2) from account_group
+		inner join group_member on account_group.id = group_member.group_id
+		where group_member.account_id = $1`, accountId, DefaultMaxSnapshots).Scan(&limit)
+	if err != nil {
+		return -1, fmt.Errorf("load snapshot limit of account %d: %v", accountId, err)
+	}
+	return limit, nil
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SSHKey is a public key of an account, which is authorized on the instances
+// the account creates.
+type SSHKey struct {
+	Id          int       `json:"id"`
+	AccountId   int       `json:"-"`
+	Name        string    `json:"name"`
+	PublicKey   string    `json:"public_key"`
+	Type        string    `json:"type"`
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+}
+
+const sshKeyColumns = "id, account_id, name, public_key, key_type, fingerprint, created"
+
+func scanSSHKey(row pgx.Row) (*SSHKey, error) {
+	var k SSHKey
+	err := row.Scan(&k.Id, &k.AccountId, &k.Name, &k.PublicKey, &k.Type, &k.Fingerprint, &k.Created)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// InsertSSHKey adds the key to its account, unless the account has a key of
+// the same name or fingerprint already, in which case false is returned.
+func InsertSSHKey(ctx context.Context, pool *pgxpool.Pool, key *SSHKey) (bool, error) {
+	err := pool.QueryRow(ctx,
+		`insert into ssh_key (account_id, name, public_key, key_type, fingerprint) values ($1, $2, $3, $4, $5)
+		on conflict do nothing returning id, created`,
+		key.AccountId, key.Name, key.PublicKey, key.Type, key.Fingerprint).Scan(&key.Id, &key.Created)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("insert SSH key %s of account %d: %v", key.Name, key.AccountId, err)
+	}
+	return true, nil
+}
+
+// LoadSSHKeys returns the keys of the account, ordered by name.
+func LoadSSHKeys(ctx context.Context, pool *pgxpool.Pool, accountId int) ([]*SSHKey, error) {
+	rows, err := pool.Query(ctx,
+		"select "+sshKeyColumns+" from ssh_key where account_id = $1 order by name", accountId)
+	if err != nil {
+		return nil, fmt.Errorf("load SSH keys of account %d: %v", accountId, err)
+	}
+	defer rows.Close()
+	keys := make([]*SSHKey, 0)
+	for rows.Next() {
+		key, err := scanSSHKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan SSH key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// LoadSSHKey returns the key of the account, or nil if the account has no key
+// with that id.
+func LoadSSHKey(ctx context.Context, pool *pgxpool.Pool, accountId, id int) (*SSHKey, error) {
+	key, err := scanSSHKey(pool.QueryRow(ctx,
+		"select "+sshKeyColumns+" from ssh_key where id = $1 and account_id = $2", id, accountId))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load SSH key %d: %v", id, err)
+	}
+	return key, nil
+}
+
+func DeleteSSHKey(ctx context.Context, pool *pgxpool.Pool, id int) error {
+	if _, err := pool.Exec(ctx, "delete from ssh_key where id = $1", id); err != nil {
+		return fmt.Errorf("delete SSH key %d: %v", id, err)
+	}
+	return nil
+}
+
+// IsSSHKeyShared returns true if another key record has the same public key,
+// which is then registered with the cloud under the same name.
+func IsSSHKeyShared(ctx context.Context, pool *pgxpool.Pool, key *SSHKey) (bool, error) {
+	var shared bool
+	err := pool.QueryRow(ctx, "select exists (select 1 from ssh_key where fingerprint = $1 and id <> $2)",
+		key.Fingerprint, key.Id).Scan(&shared)
+	if err != nil {
+		return false, fmt.Errorf("check whether SSH key %d is shared: %v", key.Id, err)
+	}
+	return shared, nil
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TenantSettings struct {
+	Tenant           string
+	LoginLinkEnabled bool
+	// ExamMode blocks actions that could be used to cheat during an exam.
+	ExamMode bool
+}
+
+// LoadTenantSettings returns the settings of the tenant, falling back to the
+// defaults if no settings have been stored for it yet.
+func LoadTenantSettings(ctx context.Context, pool *pgxpool.Pool, tenant string) (*TenantSettings, error) {
+	settings := TenantSettings{Tenant: tenant}
+	err := pool.QueryRow(ctx, "select login_link_enabled, exam_mode from tenant_setting where tenant = $1", tenant).
+		Scan(&settings.LoginLinkEnabled, &settings.ExamMode)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("load settings of tenant '%s': %v", tenant, err)
+	}
+	return &settings, nil
+}
+
+func SaveTenantSettings(ctx context.Context, pool *pgxpool.Pool, settings *TenantSettings) error {
+	_, err := pool.Exec(ctx,
+		`insert into tenant_setting (tenant, login_link_enabled, exam_mode) values ($1, $2, $3)
+		on conflict (tenant) do update set login_link_enabled = excluded.login_link_enabled,
+			exam_mode = excluded.exam_mode`,
+		settings.Tenant, settings.LoginLinkEnabled, settings.ExamMode)
+	if err != nil {
+		return fmt.Errorf("save settings of tenant '%s': %v", settings.Tenant, err)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxBulkParallelism is the number of instances acted on concurrently by a bulk
+// action at most, which keeps a large group from running into the provider's
+// rate limits.
+const maxBulkParallelism = 5
+
+// bulkTimeout is how long the actions of a bulk action may take altogether.
+const bulkTimeout = 5 * time.Minute
+
+// bulkActions maps the actions teachers may carry out on all instances of a
+// group to the kinds of the actions.
+var bulkActions = map[string]db.Kind{
+	"start":    db.INSTANCE_START,
+	"stop":     db.INSTANCE_STOP,
+	"reboot":   db.INSTANCE_REBOOT,
+	"snapshot": db.INSTANCE_SNAPSHOT,
+}
+
+// bulkResult reports the outcome of a bulk action on one instance. Status is
+// the status the action on the single instance would have been answered
+// with.
+type bulkResult struct {
+	InstanceId    string `json:"instance_id"`
+	Name          string `json:"name"`
+	Status        int    `json:"status"`
+	InstanceState string `json:"instance_state,omitempty"`
+	OperationId   int    `json:"operation_id,omitempty"`
+	SnapshotId    string `json:"snapshot_id,omitempty"`
+}
+
+// bulkTarget is an instance selected for a bulk action, and the access to the
+// zone it was assigned in.
+type bulkTarget struct {
+	api      *cloud.Access
+	instance *cloud.Instance
+}
+
+// BulkAction carries out the action given in the body on every instance of
+// the group given by the id path value, i.e. the instances assigned to the
+// group or to its members. If labels are given, only the instances having all
+// of them are acted on. Only teachers of the group may do so. The instances
+// are acted on concurrently and each action is logged for the teacher. Every
+// instance is reported with the status its own action ended with, so the
+// request succeeds even if the action failed on some instances.
+func (s *Stateful) BulkAction(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Action string            `json:"action"`
+		Labels map[string]string `json:"labels"`
+		Name   string            `json:"name"`
+	}
+	groupId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	teacher := s.getGroupTeacher(w, r, groupId)
+	if teacher == nil {
+		return
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal bulk action: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	kind, ok := bulkActions[payload.Action]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown bulk action %q\n", payload.Action)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if kind == db.INSTANCE_SNAPSHOT && (payload.Name == "" || len(payload.Name) > 100) {
+		fmt.Fprintf(os.Stderr, "invalid snapshot name %q\n", payload.Name)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	assigned, err := db.LoadGroupInstances(r.Context(), s.Pool, groupId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	targets, results := s.selectBulkTargets(r.Context(), teacher, assigned, payload.Labels)
+	// the actions requested have to be recorded even if the caller is gone,
+	// so they only end with the server or the timeout
+	ctx, cancel := context.WithTimeout(s.ctx, bulkTimeout)
+	defer cancel()
+	var group errgroup.Group
+	group.SetLimit(s.bulkParallelism())
+	for i, target := range targets {
+		group.Go(func() error {
+			if r.Context().Err() != nil {
+				// the caller is gone, the remaining instances are left alone
+				return nil
+			}
+			results[i] = s.bulkAction(ctx, target, teacher, kind, payload.Name)
+			return nil
+		})
+	}
+	group.Wait()
+	slices.SortFunc(results, func(a, b bulkResult) int {
+		return strings.Compare(a.Name+a.InstanceId, b.Name+b.InstanceId)
+	})
+	data, err := json.Marshal(results)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal bulk action payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// bulkParallelism returns the number of instances acted on concurrently, which
+// leaves most connections of the pool to other requests.
+func (s *Stateful) bulkParallelism() int {
+	return max(1, min(maxBulkParallelism, int(s.Pool.Config().MaxConns)/4))
+}
+
+// selectBulkTargets looks up the assigned instances in the zones they were
+// assigned in and returns the ones having all the labels. It also returns the
+// results to be filled in for the targets, in the same order, followed by the
+// results of the instances that could not be looked up.
+func (s *Stateful) selectBulkTargets(ctx context.Context, teacher *db.Account, assigned map[string]string, labels map[string]string) ([]bulkTarget, []bulkResult) {
+	zones := make(map[string][]string)
+	for id, zone := range assigned {
+		zones[zone] = append(zones[zone], id)
+	}
+	targets := make([]bulkTarget, 0, len(assigned))
+	failed := make([]bulkResult, 0)
+	for _, zone := range slices.Sorted(maps.Keys(zones)) {
+		ids := zones[zone]
+		instances, api, err := s.zoneInstances(ctx, teacher, zone)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "get instances in zone %s: %v\n", zone, err)
+			for _, id := range ids {
+				failed = append(failed, bulkResult{InstanceId: id, Status: providerErrorStatus(err)})
+			}
+			continue
+		}
+		for _, id := range ids {
+			instance, ok := instances[id]
+			if !ok {
+				if len(labels) == 0 {
+					failed = append(failed, bulkResult{InstanceId: id, Status: http.StatusNotFound})
+				}
+				continue
+			}
+			if hasLabels(instance, labels) {
+				targets = append(targets, bulkTarget{api: api, instance: instance})
+			}
+		}
+	}
+	results := make([]bulkResult, len(targets), len(targets)+len(failed))
+	return targets, append(results, failed...)
+}
+
+// zoneInstances returns the instances in the zone, mapped to their IDs, and
+// the access to the zone.
+func (s *Stateful) zoneInstances(ctx context.Context, teacher *db.Account, zone string) (map[string]*cloud.Instance, *cloud.Access, error) {
+	var api *cloud.Access
+	var err error
+	if zone == "" {
+		api, err = s.GetAPIAccess(teacher.Name)
+	} else {
+		api, err = s.GetAPIAccessInZone(teacher.Name, zone)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	list, err := api.GetInstances(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	instances := make(map[string]*cloud.Instance, len(list))
+	for _, instance := range list {
+		instances[instance.ID] = instance
+	}
+	return instances, api, nil
+}
+
+func hasLabels(instance *cloud.Instance, labels map[string]string) bool {
+	for key, value := range labels {
+		if instanceValue, ok := instance.Labels[key]; !ok || instanceValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// bulkAction carries out the action of the given kind on the target on behalf
+// of the teacher, like the endpoint for the action on a single instance, and
+// reports its outcome.
+func (s *Stateful) bulkAction(ctx context.Context, target bulkTarget, teacher *db.Account, kind db.Kind, snapshotName string) bulkResult {
+	api, id := target.api, target.instance.ID
+	result := bulkResult{InstanceId: id, Name: target.instance.Name}
+	if kind == db.INSTANCE_SNAPSHOT {
+		snapshot, err := s.takeSnapshot(ctx, api, teacher, id, snapshotName)
+		if err != nil {
+			return failedBulkResult(result, err)
+		}
+		result.Status, result.SnapshotId = http.StatusCreated, snapshot.SnapshotId
+		return result
+	}
+	operation, state, err := s.instanceAction(ctx, api, teacher, id, kind)
+	if err != nil {
+		return failedBulkResult(result, err)
+	}
+	result.Status, result.InstanceState, result.OperationId = http.StatusAccepted, state, operation.Id
+	return result
+}
+
+// failedBulkResult reports the error of the action in the result, along with
+// the state of the instance if the action is invalid in it.
+func failedBulkResult(result bulkResult, err error) bulkResult {
+	fmt.Fprintln(os.Stderr, err)
+	result.Status = actionErrorStatus(err)
+	var refused *transitionError
+	if errors.As(err, &refused) {
+		result.InstanceState = refused.state
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// GetZones lists the zones offered by the cloud of the caller's tenant.
+func (s *Stateful) GetZones(w http.ResponseWriter, r *http.Request) {
+	serveCatalog(s, w, r, s.Catalog.Zones)
+}
+
+// GetCatalogInstanceTypes lists the instance types offered in the caller's
+// zone, with their CPU count and memory.
+func (s *Stateful) GetCatalogInstanceTypes(w http.ResponseWriter, r *http.Request) {
+	serveCatalog(s, w, r, s.Catalog.InstanceTypes)
+}
+
+// GetTemplates lists the public templates and the templates private to the
+// caller's tenant.
+func (s *Stateful) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	serveCatalog(s, w, r, s.Catalog.Templates)
+}
+
+func serveCatalog[T any](s *Stateful, w http.ResponseWriter, r *http.Request, list func(context.Context, *cloud.Access) (T, error)) {
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return
+	}
+	entries, err := list(r.Context(), api)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal catalog payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// validInstanceType checks whether the caller's zone offers the instance type.
+// Otherwise, an error status is written.
+func (s *Stateful) validInstanceType(w http.ResponseWriter, r *http.Request, api *cloud.Access, name string) bool {
+	instanceType, err := s.Catalog.FindInstanceType(r.Context(), api, name)
+	if err != nil {
+		writeProviderError(w, err)
+		return false
+	}
+	if instanceType == nil {
+		fmt.Fprintf(os.Stderr, "instance type %s not offered in zone %s\n", name, api.Zone)
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,231 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// DeleteInstance stops the instance given by the id path value and marks it
+// for deletion after the configured grace period. The caller has to confirm
+// the deletion by passing the instance's name as the confirm query parameter.
+// Snapshots are destroyed along with the instance, so snapshot=true, asking to
+// keep one, is refused.
+func (s *Stateful) DeleteInstance(w http.ResponseWriter, r *http.Request) {
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	id := r.PathValue("id")
+	if !s.authorizeDeletion(w, r, account, id) {
+		return
+	}
+	if api = s.locateInstance(w, r, api, id); api == nil {
+		return
+	}
+	instance, err := api.GetInstance(r.Context(), id)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if r.URL.Query().Get("snapshot") == "true" {
+		fmt.Fprintf(os.Stderr, "snapshot of instance %s would be destroyed along with it\n", id)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("confirm") != instance.Name {
+		fmt.Fprintf(os.Stderr, "deletion of instance %s not confirmed by its name\n", id)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	state, release, ok := s.beginTransition(w, r, api, id, db.INSTANCE_DELETING)
+	if !ok {
+		return
+	}
+	defer release()
+	if existing, err := db.LoadDeletion(r.Context(), s.Pool, id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if existing != nil {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if state != "stopped" {
+		if _, err := api.StopInstance(r.Context(), id); err != nil {
+			writeProviderError(w, err)
+			return
+		}
+		db.LogEvent(r.Context(), s.Pool, db.INSTANCE_STOP, account.Id, "instance", id)
+	}
+	deletion := db.Deletion{
+		InstanceId: id,
+		Zone:       api.Zone,
+		Tenant:     account.Tenant,
+		AccountId:  account.Id,
+		Due:        time.Now().Add(s.Config.DeletionGracePeriod),
+	}
+	if err := db.InsertDeletion(r.Context(), s.Pool, &deletion); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_DELETING, account.Id, "instance", id)
+	payload, err := json.Marshal(deletion)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal deletion payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(payload)
+}
+
+// RestoreInstance cancels the pending deletion of the instance given by the id
+// path value. The instance stays stopped.
+func (s *Stateful) RestoreInstance(w http.ResponseWriter, r *http.Request) {
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	id := r.PathValue("id")
+	if !s.authorizeDeletion(w, r, account, id) {
+		return
+	}
+	release, ok := s.lockInstance(w, r, id)
+	if !ok {
+		return
+	}
+	defer release()
+	cancelled, err := db.CancelDeletion(r.Context(), s.Pool, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_RESTORED, account.Id, "instance", id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorizeDeletion checks whether the account may delete the instance:
+// teachers may delete the instances of their groups, owners the instances
+// created from offerings that allow deletion. Otherwise, an error status is
+// written.
+func (s *Stateful) authorizeDeletion(w http.ResponseWriter, r *http.Request, account *db.Account, id string) bool {
+	permission, err := db.LoadInstancePermission(r.Context(), s.Pool, id, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	allowed := false
+	if account.Role == db.ROLE_TEACHER {
+		allowed = permission.Includes(db.PERMISSION_OWNER)
+		if !allowed {
+			allowed, err = db.IsTeacherOfInstance(r.Context(), s.Pool, id, account.Id)
+		}
+	} else if permission.Includes(db.PERMISSION_OWNER) {
+		var offering *db.Offering
+		offering, err = db.LoadInstanceOffering(r.Context(), s.Pool, id)
+		allowed = offering != nil && offering.AllowDelete
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		fmt.Fprintf(os.Stderr, "account %s may not delete instance %s\n", account.Name, id)
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// RunDeletions destroys the instances whose deletion is due, checking every
+// interval until the context is done.
+func (s *Stateful) RunDeletions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.processDueDeletions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// deletionLease is how long a replica of the backend may take to destroy the
+// instances it claimed, before others may claim them.
+const deletionLease = 15 * time.Minute
+
+func (s *Stateful) processDueDeletions(ctx context.Context) {
+	deletions, err := db.ClaimDueDeletions(ctx, s.Pool, deletionLease)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	for _, deletion := range deletions {
+		release, err := db.TryLockInstance(ctx, s.Pool, deletion.InstanceId)
+		if err != nil || release == nil {
+			// retried once the action on the instance is done
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if err := db.ReleaseDeletion(ctx, s.Pool, deletion.Id); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			continue
+		}
+		// the instance may have been restored since the deletion was claimed
+		if current, err := db.LoadDeletion(ctx, s.Pool, deletion.InstanceId); err != nil || current == nil || current.Id != deletion.Id {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			release()
+			continue
+		}
+		if err := s.destroyInstance(ctx, deletion); err != nil {
+			fmt.Fprintf(os.Stderr, "destroy instance %s: %v\n", deletion.InstanceId, err)
+			if err := db.RecordDeletionFailure(ctx, s.Pool, deletion.Id, err); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+		release()
+	}
+}
+
+func (s *Stateful) destroyInstance(ctx context.Context, deletion *db.Deletion) error {
+	api, err := s.GetAPIAccessForTenantInZone(deletion.Tenant, deletion.Zone)
+	if err != nil {
+		return err
+	}
+	if err := api.DeleteInstance(ctx, deletion.InstanceId); err != nil {
+		return err
+	}
+	if err := api.DeleteInstanceSecurityGroup(ctx, deletion.InstanceId); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if err := db.CompleteDeletion(ctx, s.Pool, deletion); err != nil {
+		return err
+	}
+	db.LogEvent(ctx, s.Pool, db.INSTANCE_DELETED, deletion.AccountId, "instance", deletion.InstanceId)
+	return nil
+}
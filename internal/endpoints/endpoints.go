@@ -11,43 +11,161 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/composed-ch/cloud-castle-backend/cloud"
 	"github.com/composed-ch/cloud-castle-backend/exoscale"
 	"github.com/composed-ch/cloud-castle-backend/internal/auth"
 	"github.com/composed-ch/cloud-castle-backend/internal/config"
 	"github.com/composed-ch/cloud-castle-backend/internal/db"
 	"github.com/composed-ch/cloud-castle-backend/internal/mailing"
+	"github.com/composed-ch/cloud-castle-backend/openstack"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Stateful struct {
-	Pool   *pgxpool.Pool
-	Config *config.Config
+	Pool    *pgxpool.Pool
+	Config  *config.Config
+	Catalog *cloud.Catalog
+	// Providers create the providers the API keys are used with, by the kind
+	// of cloud the keys are for.
+	Providers cloud.Providers
+	states    *stateHub
+	// ctx ends the work outliving the requests, e.g. tracking operations,
+	// once the server shuts down.
+	ctx      context.Context
+	tracking sync.WaitGroup
 }
 
-func NewStateful(cfg *config.Config) (*Stateful, error) {
+// NewStateful creates the state shared by the endpoints. Work started in the
+// background by the endpoints stops once the context is done.
+func NewStateful(ctx context.Context, cfg *config.Config) (*Stateful, error) {
 	pool, err := pgxpool.New(context.Background(), cfg.BuildDatabaseURL())
 	if err != nil {
 		return nil, fmt.Errorf("create connection pool: %w", err)
 	}
-	return &Stateful{Pool: pool, Config: cfg}, nil
+	exoscale.SetInstanceCacheTTL(cfg.InstanceCacheTTL)
+	exoscale.SetCallTimeout(cfg.ExoscaleTimeout)
+	exoscale.SetRetries(cfg.ExoscaleRetries)
+	return &Stateful{
+		Pool:    pool,
+		Config:  cfg,
+		Catalog: cloud.NewCatalog(cfg.CatalogTTL),
+		Providers: cloud.Providers{
+			"exoscale":  exoscale.NewProvider,
+			"openstack": openstack.NewProvider,
+		},
+		states: newStateHub(cfg.StatePollInterval),
+		ctx:    ctx,
+	}, nil
 }
 
-func (s *Stateful) GetAPIAccess(username string) (*exoscale.APIAccess, error) {
-	var zone, key, secret string
-	err := s.Pool.QueryRow(context.Background(),
-		`select zone, api_key, api_secret
+// credentialColumns are the columns of api_key scanned by scanCredentials.
+const credentialColumns = `api_key.provider, api_key.zone, coalesce(api_key.endpoint, ''),
+	api_key.api_key, api_key.api_secret`
+
+func scanCredentials(row pgx.Row) (cloud.Credentials, error) {
+	var creds cloud.Credentials
+	err := row.Scan(&creds.Provider, &creds.Zone, &creds.Endpoint, &creds.Key, &creds.Secret)
+	return creds, err
+}
+
+// GetAPIAccess returns API access using the first key registered for the
+// user's tenant, whose zone is the tenant's default zone.
+func (s *Stateful) GetAPIAccess(username string) (*cloud.Access, error) {
+	creds, err := scanCredentials(s.Pool.QueryRow(context.Background(),
+		`select `+credentialColumns+`
 		from api_key
 		inner join account on api_key.tenant = account.tenant
 		where lower(account.name) = lower($1)
-		limit 1`, username).Scan(&zone, &key, &secret)
+		order by api_key.id
+		limit 1`, username))
 	if err != nil {
 		return nil, fmt.Errorf("get API key for %s: %w", username, err)
 	}
-	return exoscale.NewAPIAccess(username, zone, key, secret), nil
+	return s.Providers.Access(username, creds)
+}
+
+// GetAPIAccesses returns API access to every zone the user's tenant has a key
+// for.
+func (s *Stateful) GetAPIAccesses(username string) ([]*cloud.Access, error) {
+	return s.queryAPIAccesses(username,
+		`select distinct on (zone) `+credentialColumns+`
+		from api_key
+		inner join account on api_key.tenant = account.tenant
+		where lower(account.name) = lower($1)
+		order by zone`, username)
+}
+
+// GetAPIAccessesForTenant returns API access to every zone the tenant has a
+// key for, for actions not carried out on behalf of a particular user.
+func (s *Stateful) GetAPIAccessesForTenant(tenant string) ([]*cloud.Access, error) {
+	return s.queryAPIAccesses("",
+		`select distinct on (zone) `+credentialColumns+`
+		from api_key where tenant = $1
+		order by zone`, tenant)
+}
+
+func (s *Stateful) queryAPIAccesses(username, query, arg string) ([]*cloud.Access, error) {
+	rows, err := s.Pool.Query(context.Background(), query, arg)
+	if err != nil {
+		return nil, fmt.Errorf("get API keys for %s: %w", arg, err)
+	}
+	defer rows.Close()
+	accesses := make([]*cloud.Access, 0)
+	for rows.Next() {
+		creds, err := scanCredentials(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan API key: %w", err)
+		}
+		access, err := s.Providers.Access(username, creds)
+		if err != nil {
+			return nil, err
+		}
+		accesses = append(accesses, access)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get API keys for %s: %w", arg, err)
+	}
+	if len(accesses) == 0 {
+		return nil, fmt.Errorf("no API key for %s", arg)
+	}
+	return accesses, nil
+}
+
+// GetAPIAccessInZone returns API access to the given zone, using the tenant's
+// key for that zone or, failing that, any key of the tenant, since Exoscale
+// keys are valid in all zones, as are OpenStack credentials in all regions of
+// their cloud.
+func (s *Stateful) GetAPIAccessInZone(username, zone string) (*cloud.Access, error) {
+	creds, err := scanCredentials(s.Pool.QueryRow(context.Background(),
+		`select `+credentialColumns+`
+		from api_key
+		inner join account on api_key.tenant = account.tenant
+		where lower(account.name) = lower($1)
+		order by api_key.zone = $2 desc
+		limit 1`, username, zone))
+	if err != nil {
+		return nil, fmt.Errorf("get API key for %s in zone %s: %w", username, zone, err)
+	}
+	creds.Zone = zone
+	return s.Providers.Access(username, creds)
+}
+
+// GetAPIAccessForTenantInZone returns API access to the given zone using the
+// tenant's key for that zone or, failing that, any key of the tenant.
+func (s *Stateful) GetAPIAccessForTenantInZone(tenant, zone string) (*cloud.Access, error) {
+	creds, err := scanCredentials(s.Pool.QueryRow(context.Background(),
+		`select `+credentialColumns+` from api_key where tenant = $1 order by zone = $2 desc limit 1`,
+		tenant, zone))
+	if err != nil {
+		return nil, fmt.Errorf("get API key for tenant %s in zone %s: %w", tenant, zone, err)
+	}
+	creds.Zone = zone
+	return s.Providers.Access("", creds)
 }
 
 type authRequest struct {
@@ -103,41 +221,83 @@ func (s *Stateful) Login(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetInstances lists the instances assigned to the caller in all zones of the
+// caller's tenant. Zones that cannot be listed are named in the
+// X-Unavailable-Zones header; only if all zones fail, an error is returned.
 func (s *Stateful) GetInstances(w http.ResponseWriter, r *http.Request) {
-	api := s.getAPIAccess(w, r)
-	if api == nil {
+	type zoneResult struct {
+		instances []*cloud.Instance
+		err       error
+	}
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	accesses, err := s.GetAPIAccesses(account.Name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get API access: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	instances, err := api.GetOwnInstances(api.Username)
+	assigned, err := db.LoadAssignedInstances(r.Context(), s.Pool, account.Id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "get instances: %v\n", err)
+		fmt.Fprintf(os.Stderr, "load assigned instances: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	payload, err := json.Marshal(instances)
+	results := make([]zoneResult, len(accesses))
+	var wg sync.WaitGroup
+	for i, api := range accesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].instances, results[i].err = api.GetInstances(r.Context())
+		}()
+	}
+	wg.Wait()
+	ownInstances := make([]*cloud.Instance, 0)
+	unavailable := make([]string, 0)
+	for i, result := range results {
+		if result.err != nil {
+			fmt.Fprintf(os.Stderr, "get instances in zone %s: %v\n", accesses[i].Zone, result.err)
+			unavailable = append(unavailable, accesses[i].Zone)
+			continue
+		}
+		for _, instance := range result.instances {
+			if _, ok := assigned[instance.ID]; ok {
+				ownInstances = append(ownInstances, instance)
+			}
+		}
+	}
+	if len(unavailable) == len(accesses) {
+		if len(results) > 0 {
+			writeProviderError(w, results[0].err)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	payload, err := json.Marshal(ownInstances)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "marshal instances payload: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if len(unavailable) > 0 {
+		w.Header().Set("X-Unavailable-Zones", strings.Join(unavailable, ","))
+	}
 	w.Write(payload)
 }
 
 func (s *Stateful) GetInstanceState(w http.ResponseWriter, r *http.Request) {
-	api := s.getAPIAccess(w, r)
-	if api == nil {
+	api, _, ok := s.authorizeInstance(w, r, db.PERMISSION_VIEW)
+	if !ok {
 		return
 	}
 	id := r.PathValue("id")
-	instance, err := api.GetInstance(id)
+	instance, err := api.GetInstance(r.Context(), id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "get instance: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	owner, ok := instance.Labels["owner"]
-	if !ok || owner != api.Username {
-		w.WriteHeader(http.StatusUnauthorized)
+		writeProviderError(w, err)
 		return
 	}
 	payload, err := json.Marshal(map[string]string{"state": instance.State})
@@ -148,68 +308,180 @@ func (s *Stateful) GetInstanceState(w http.ResponseWriter, r *http.Request) {
 	w.Write(payload)
 }
 
-func (s *Stateful) StartInstance(w http.ResponseWriter, r *http.Request) {
-	api := s.getAPIAccess(w, r)
-	if api == nil {
+// GetInstance returns the details of the instance given by the id path value,
+// including the ingress rules requested for it.
+func (s *Stateful) GetInstance(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		*cloud.Instance
+		Rules []*db.IngressRule `json:"rules"`
+	}
+	api, _, ok := s.authorizeInstance(w, r, db.PERMISSION_VIEW)
+	if !ok {
 		return
 	}
 	id := r.PathValue("id")
-	instance, err := api.GetInstance(id)
+	instance, err := api.GetInstanceDetail(r.Context(), id)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "get instance: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	owner, ok := instance.Labels["owner"]
-	if !ok || owner != api.Username {
-		w.WriteHeader(http.StatusUnauthorized)
+		writeProviderError(w, err)
 		return
 	}
-	err = api.StartInstance(id)
+	rules, err := db.LoadIngressRules(r.Context(), s.Pool, id)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	accountId, err := db.LoadAccountIdByName(r.Context(), s.Pool, owner)
+	payload, err := json.Marshal(Response{Instance: instance, Rules: rules})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "load account by name '%s': %v", owner, err)
-	} else {
-		db.LogEvent(r.Context(), s.Pool, db.INSTANCE_START, accountId, "instance", id)
+		fmt.Fprintf(os.Stderr, "marshal instance payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	w.WriteHeader(200)
+	w.Write(payload)
 }
 
-func (s *Stateful) StopInstance(w http.ResponseWriter, r *http.Request) {
-	api := s.getAPIAccess(w, r)
-	if api == nil {
+// GetConsoleURL returns a short-lived URL to the console of the instance given
+// by the id path value. Every access is logged, so that teachers can tell who
+// used the console, e.g. during an exam.
+func (s *Stateful) GetConsoleURL(w http.ResponseWriter, r *http.Request) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OPERATE)
+	if !ok {
 		return
 	}
 	id := r.PathValue("id")
-	instance, err := api.GetInstance(id)
+	consoleURL, err := api.GetConsoleURL(r.Context(), id)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	payload, err := json.Marshal(consoleURL)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "get instance: %v\n", err)
+		fmt.Fprintf(os.Stderr, "marshal console URL payload: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	owner, ok := instance.Labels["owner"]
-	if !ok || owner != api.Username {
-		w.WriteHeader(http.StatusUnauthorized)
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_CONSOLE, account.Id, "instance", id)
+	w.Write(payload)
+}
+
+func (s *Stateful) StartInstance(w http.ResponseWriter, r *http.Request) {
+	s.operationAction(w, r, db.INSTANCE_START)
+}
+
+func (s *Stateful) StopInstance(w http.ResponseWriter, r *http.Request) {
+	s.operationAction(w, r, db.INSTANCE_STOP)
+}
+
+func (s *Stateful) RebootInstance(w http.ResponseWriter, r *http.Request) {
+	s.operationAction(w, r, db.INSTANCE_REBOOT)
+}
+
+// ForceStopInstance stops an instance that does not react to a regular stop,
+// e.g. because it hangs during shutdown.
+func (s *Stateful) ForceStopInstance(w http.ResponseWriter, r *http.Request) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OPERATE)
+	if !ok {
 		return
 	}
-	err = api.StopInstance(id)
-	if err != nil {
+	id := r.PathValue("id")
+	state, release, ok := s.beginTransition(w, r, api, id, db.INSTANCE_FORCE_STOP)
+	if !ok {
+		return
+	}
+	defer release()
+	if err := api.ForceStopInstance(r.Context(), id, forceStopTimeout); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if operation, err := db.LoadPendingOperation(r.Context(), s.Pool, id); err != nil {
 		fmt.Fprintln(os.Stderr, err)
+	} else if operation != nil {
+		// the operation interrupted would keep its transitional state until it times out
+		if err := db.FinishOperation(r.Context(), s.Pool, operation.Id, errors.New("interrupted by forced stop")); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_FORCE_STOP, account.Id, "instance", id)
+	writeState(w, http.StatusOK, state)
+}
+
+// forceStopTimeout is how long a forced stop waits for the instance to be
+// stopped before the stop is requested once more.
+const forceStopTimeout = time.Minute
+
+// operationAction requests the action of the given kind on the instance given
+// by the id path value. The operation carrying out the action is returned with
+// 202 for the caller to poll, along with the state the instance is in now.
+func (s *Stateful) operationAction(w http.ResponseWriter, r *http.Request, kind db.Kind) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OPERATE)
+	if !ok {
+		return
+	}
+	operation, state, err := s.instanceAction(r.Context(), api, account, r.PathValue("id"), kind)
+	if err != nil {
+		writeActionError(w, err)
+		return
+	}
+	payload, err := json.Marshal(struct {
+		*db.Operation
+		InstanceState string `json:"instance_state"`
+	}{operation, state})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal operation payload: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	accountId, err := db.LoadAccountIdByName(r.Context(), s.Pool, owner)
+	w.Header().Set("Location", fmt.Sprintf("/operations/%d", operation.Id))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(payload)
+}
+
+// operationActions maps the kinds of the actions carried out by an operation
+// to the provider calls requesting them.
+var operationActions = map[db.Kind]func(*cloud.Access, context.Context, string) (string, error){
+	db.INSTANCE_START:  (*cloud.Access).StartInstance,
+	db.INSTANCE_STOP:   (*cloud.Access).StopInstance,
+	db.INSTANCE_REBOOT: (*cloud.Access).RebootInstance,
+}
+
+// instanceAction requests the action of the given kind on the instance on
+// behalf of the account and logs it. The operation carrying out the action is
+// tracked in the background. It returns the operation and the state the
+// instance is in now. Actions booting the instance are refused with
+// errPendingDeletion for instances pending deletion, and actions invalid in
+// the current state with a *transitionError.
+func (s *Stateful) instanceAction(ctx context.Context, api *cloud.Access, account *db.Account, id string, kind db.Kind) (*db.Operation, string, error) {
+	if kind == db.INSTANCE_START || kind == db.INSTANCE_REBOOT {
+		deletion, err := db.LoadDeletion(ctx, s.Pool, id)
+		if err != nil {
+			return nil, "", err
+		} else if deletion != nil {
+			return nil, "", fmt.Errorf("refuse %s of instance %s: %w", kind, id, errPendingDeletion)
+		}
+	}
+	state, release, err := s.lockTransition(ctx, api, id, kind)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "load account by name '%s': %v", owner, err)
-	} else {
-		db.LogEvent(r.Context(), s.Pool, db.INSTANCE_STOP, accountId, "instance", id)
+		return nil, "", err
 	}
-	w.WriteHeader(200)
+	defer release()
+	providerId, err := operationActions[kind](api, ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	db.LogEvent(ctx, s.Pool, kind, account.Id, "instance", id)
+	operation := db.Operation{
+		AccountId:  account.Id,
+		InstanceId: id,
+		Zone:       api.Zone,
+		Tenant:     account.Tenant,
+		Kind:       kind,
+		ProviderId: providerId,
+	}
+	if err := db.InsertOperation(ctx, s.Pool, &operation); err != nil {
+		return nil, "", err
+	}
+	s.trackOperation(api, &operation)
+	return &operation, state, nil
 }
 
 func (s *Stateful) ResetPassword(w http.ResponseWriter, r *http.Request) {
@@ -223,32 +495,11 @@ func (s *Stateful) ResetPassword(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	accountId, err := db.LoadAccountIdByEmail(r.Context(), s.Pool, payload.Email)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			fmt.Fprintf(os.Stderr, "no account found for password reset by email %s: %v\n", payload.Email, err)
-			w.WriteHeader(http.StatusCreated)
-		} else {
-			fmt.Fprintf(os.Stderr, "fetch account for password reset by email %s: %v\n", payload.Email, err)
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+	accountId, ok := s.accountForTokenRequest(w, r, "password_reset", payload.Email)
+	if !ok {
 		return
 	}
-	var created sql.NullTime
 	hasExistingRequest := false
-	err = s.Pool.QueryRow(r.Context(),
-		"select max(created) from password_reset where account_id = $1", accountId).Scan(&created)
-	if err == nil && created.Valid && created.Time.Add(time.Minute*5).After(time.Now()) {
-		fmt.Fprintf(os.Stderr, "password reset request coming in too soon for %s\n", payload.Email)
-		w.WriteHeader(http.StatusTooManyRequests)
-		return
-	} else if err != nil {
-		if !errors.Is(err, pgx.ErrNoRows) {
-			fmt.Fprintf(os.Stderr, "query for existing password reset requests for %s: %v\n", payload.Email, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-	}
 	token, err := auth.RandomPasswordAlnum(64)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "generate random password: %v\n", err)
@@ -291,6 +542,43 @@ func (s *Stateful) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	db.LogEvent(r.Context(), s.Pool, db.PASSWORD_REQUESTED, accountId, "email", payload.Email)
 }
 
+// tokenRequestInterval is the minimum time between two emailed token requests
+// (password reset, login link) of the same account.
+const tokenRequestInterval = time.Minute * 5
+
+// accountForTokenRequest looks up the account for which a token shall be sent
+// by email. Unknown addresses are answered with 201 Created, so that callers
+// cannot probe for existing accounts. Requests coming in sooner than
+// tokenRequestInterval after the latest entry in table are rejected.
+func (s *Stateful) accountForTokenRequest(w http.ResponseWriter, r *http.Request, table, email string) (int, bool) {
+	accountId, err := db.LoadAccountIdByEmail(r.Context(), s.Pool, email)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "no account found for %s request by email %s: %v\n", table, email, err)
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			fmt.Fprintf(os.Stderr, "fetch account for %s request by email %s: %v\n", table, email, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return -1, false
+	}
+	var created sql.NullTime
+	err = s.Pool.QueryRow(r.Context(),
+		fmt.Sprintf("select max(created) from %s where account_id = $1", table), accountId).Scan(&created)
+	if err == nil && created.Valid && created.Time.Add(tokenRequestInterval).After(time.Now()) {
+		fmt.Fprintf(os.Stderr, "%s request coming in too soon for %s\n", table, email)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return -1, false
+	} else if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "query for existing %s requests for %s: %v\n", table, email, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return -1, false
+		}
+	}
+	return accountId, true
+}
+
 func (s *Stateful) NewPassword(w http.ResponseWriter, r *http.Request) {
 	type Payload struct {
 		Email    string `json:"email"`
@@ -351,7 +639,7 @@ func (s *Stateful) NewPassword(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *Stateful) getAPIAccess(w http.ResponseWriter, r *http.Request) *exoscale.APIAccess {
+func (s *Stateful) getAPIAccess(w http.ResponseWriter, r *http.Request) *cloud.Access {
 	authorization := r.Header.Get("Authorization")
 	subject, err := auth.ExtractSubject(authorization)
 	if err != nil {
@@ -368,6 +656,113 @@ func (s *Stateful) getAPIAccess(w http.ResponseWriter, r *http.Request) *exoscal
 	return api
 }
 
+// getAccount returns the account of the authenticated caller. Otherwise, an
+// error status is written.
+func (s *Stateful) getAccount(w http.ResponseWriter, r *http.Request) *db.Account {
+	subject, err := auth.ExtractSubject(r.Header.Get("Authorization"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "extract subject: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, subject)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	return account
+}
+
+// authorizeInstance returns the API access and the account of the caller, if
+// the caller has at least the required permission on the instance identified
+// by the id path value. Teachers have all permissions on the instances of
+// their groups. Otherwise, an error status is written.
+func (s *Stateful) authorizeInstance(w http.ResponseWriter, r *http.Request, required db.Permission) (*cloud.Access, *db.Account, bool) {
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return nil, nil, false
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	id := r.PathValue("id")
+	permission, err := db.LoadInstancePermission(r.Context(), s.Pool, id, account.Id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load instance permission: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !permission.Includes(required) && account.Role == db.ROLE_TEACHER {
+		teacher, err := db.IsTeacherOfInstance(r.Context(), s.Pool, id, account.Id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "check teacher of instance: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, nil, false
+		}
+		if teacher {
+			permission = db.PERMISSION_OWNER
+		}
+	}
+	if !permission.Includes(required) {
+		fmt.Fprintf(os.Stderr, "account %s lacks %s permission on instance %s\n", account.Name, required, id)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	api = s.locateInstance(w, r, api, id)
+	return api, account, api != nil
+}
+
+// locateInstance returns API access to the zone the instance was assigned in,
+// which is the given access if the zone is the same or unknown. Otherwise, an
+// error status is written.
+func (s *Stateful) locateInstance(w http.ResponseWriter, r *http.Request, api *cloud.Access, id string) *cloud.Access {
+	zone, err := db.LoadInstanceZone(r.Context(), s.Pool, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+	if zone == "" || zone == api.Zone {
+		return api
+	}
+	located, err := s.GetAPIAccessInZone(api.Username, zone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get API access: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+	return located
+}
+
+// writeProviderError logs the error returned by a call to the cloud provider
+// and writes the status matching its cause: 404 for missing resources, 403 for
+// refused calls, 503 if the provider is rate limiting or unavailable, 501 for
+// operations it does not offer, and 500 otherwise.
+func writeProviderError(w http.ResponseWriter, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	w.WriteHeader(providerErrorStatus(err))
+}
+
+// providerErrorStatus returns the status written by writeProviderError.
+func providerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, cloud.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cloud.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, cloud.ErrRateLimited), errors.Is(err, cloud.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, cloud.ErrUnsupported):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func jsonBody[T any](r *http.Request) (*T, error) {
 	var payload T
 	buf := bytes.NewBufferString("")
@@ -0,0 +1,875 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/cloud/fake"
+	"github.com/composed-ch/cloud-castle-backend/internal/auth"
+	"github.com/composed-ch/cloud-castle-backend/internal/config"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testZone = "ch-gva-2"
+
+// newTestState returns a Stateful using the fake cloud and a schema of its own
+// in the database given by TEST_DATABASE_URL, with all migrations applied. The
+// test is skipped if no database is given.
+func newTestState(t *testing.T) (*Stateful, *fake.Cloud) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("parse database URL: %v", err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatalf("create connection pool: %v", err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "drop schema "+schema+" cascade")
+		pool.Close()
+	})
+	if _, err := pool.Exec(ctx, "create schema "+schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	migrations, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(migrations)
+	for _, migration := range migrations {
+		content, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, _, _ := strings.Cut(string(content), "-- +goose Down")
+		if _, err := pool.Exec(ctx, up); err != nil {
+			t.Fatalf("apply %s: %v", migration, err)
+		}
+	}
+	c := fake.NewCloud()
+	return &Stateful{
+		Pool: pool,
+		Config: &config.Config{
+			DeletionGracePeriod: time.Hour,
+			CatalogTTL:          time.Minute,
+			StatePollInterval:   time.Second,
+			IdempotencyKeyTTL:   time.Hour,
+		},
+		Catalog:   cloud.NewCatalog(time.Minute),
+		Providers: cloud.Providers{"exoscale": c.NewProvider},
+		states:    newStateHub(time.Second),
+		ctx:       t.Context(),
+	}, c
+}
+
+// addAccount adds an account with an API key for the test zone to the tenant
+// and returns the account's ID and a token to authenticate with.
+func addAccount(t *testing.T, s *Stateful, tenant, name, role string) (int, string) {
+	ctx := context.Background()
+	var id int
+	err := s.Pool.QueryRow(ctx, `insert into account (name, role, tenant) values ($1, $2, $3) returning id`,
+		name, role, tenant).Scan(&id)
+	if err != nil {
+		t.Fatalf("insert account: %v", err)
+	}
+	_, err = s.Pool.Exec(ctx, `insert into api_key (zone, api_key, api_secret, tenant) values ($1, $2, 'secret', $3)
+		on conflict (api_key) do nothing`, testZone, "EXO"+tenant, tenant)
+	if err != nil {
+		t.Fatalf("insert API key: %v", err)
+	}
+	token, err := auth.IssueToken(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, token
+}
+
+func assign(t *testing.T, s *Stateful, instanceId, tenant string, accountId int, permission db.Permission) {
+	_, err := s.Pool.Exec(context.Background(), `insert into instance_assignment
+		(instance_id, zone, tenant, account_id, permission) values ($1, $2, $3, $4, $5)`,
+		instanceId, testZone, tenant, accountId, permission)
+	if err != nil {
+		t.Fatalf("insert instance assignment: %v", err)
+	}
+}
+
+// call sends a request with the body and headers to the handler, authenticated
+// with the token, and with the id as path value unless it is empty.
+func call(handler auth.Handler, method, token, id, body string, headers map[string]string) *httptest.ResponseRecorder {
+	return callTarget(handler, method, "/", token, id, body, headers)
+}
+
+// callTarget is call with a request target of its own, e.g. to pass a query.
+func callTarget(handler auth.Handler, method, target, token, id, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	if id != "" {
+		r.SetPathValue("id", id)
+	}
+	w := httptest.NewRecorder()
+	auth.Authenticated(handler)(w, r)
+	return w
+}
+
+func TestGetInstances(t *testing.T) {
+	s, c := newTestState(t)
+	zone := c.Zone(testZone)
+	own := zone.AddInstance(cloud.Instance{Name: "alice-linux"})
+	zone.AddInstance(cloud.Instance{Name: "bob-linux"})
+	alice, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	assign(t, s, own, "school", alice, db.PERMISSION_OWNER)
+
+	w := call(s.GetInstances, "GET", token, "", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), own) || strings.Contains(w.Body.String(), "bob-linux") {
+		t.Errorf("expected only the assigned instance, got %s", w.Body)
+	}
+
+	zone.Fail("GetInstances", cloud.ErrUnavailable)
+	if w := call(s.GetInstances, "GET", token, "", "", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while the provider is unavailable, got %d", w.Code)
+	}
+}
+
+func TestStartInstance(t *testing.T) {
+	s, c := newTestState(t)
+	zone := c.Zone(testZone)
+	zone.Delay = 10 * time.Millisecond
+	id := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "stopped"})
+	alice, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	_, other := addAccount(t, s, "school", "bob", db.ROLE_STUDENT)
+	assign(t, s, id, "school", alice, db.PERMISSION_OPERATE)
+
+	if w := call(s.StartInstance, "POST", other, id, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an instance not assigned, got %d", w.Code)
+	}
+	w := call(s.StartInstance, "POST", token, id, "", nil)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	var operation db.Operation
+	if err := json.Unmarshal(w.Body.Bytes(), &operation); err != nil {
+		t.Fatal(err)
+	}
+	for operation.State == db.OPERATION_PENDING {
+		time.Sleep(10 * time.Millisecond)
+		loaded, err := db.LoadOperation(context.Background(), s.Pool, operation.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		operation = *loaded
+	}
+	if operation.State != db.OPERATION_SUCCESS {
+		t.Errorf("expected operation to succeed, got %+v", operation)
+	}
+	if instance, _ := zone.GetInstance(context.Background(), id); instance.State != "running" {
+		t.Errorf("expected instance to be running, was %s", instance.State)
+	}
+
+	zone.Fail("StopInstance", cloud.ErrForbidden)
+	if w := call(s.StopInstance, "POST", token, id, "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for rejected credentials, got %d", w.Code)
+	}
+}
+
+func TestConflictingActions(t *testing.T) {
+	s, c := newTestState(t)
+	zone := c.Zone(testZone)
+	zone.Delay = 200 * time.Millisecond
+	id := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "stopped"})
+	alice, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	assign(t, s, id, "school", alice, db.PERMISSION_OPERATE)
+
+	if w := call(s.StopInstance, "POST", token, id, "", nil); w.Code != http.StatusConflict ||
+		!strings.Contains(w.Body.String(), `"instance_state":"stopped"`) {
+		t.Errorf("expected 409 with the state for stopping a stopped instance, got %d %s", w.Code, w.Body)
+	}
+	release, err := db.TryLockInstance(context.Background(), s.Pool, id)
+	if err != nil || release == nil {
+		t.Fatalf("lock instance: %v", err)
+	}
+	if w := call(s.StartInstance, "POST", token, id, "", nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 while another action holds the lock, got %d", w.Code)
+	}
+	release()
+	w := call(s.StartInstance, "POST", token, id, "", nil)
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), `"instance_state":"starting"`) {
+		t.Fatalf("expected 202 with the state starting, got %d %s", w.Code, w.Body)
+	}
+	if w := call(s.StartInstance, "POST", token, id, "", nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for starting a starting instance, got %d", w.Code)
+	}
+	if w := call(s.ForceStopInstance, "POST", token, id, "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected forced stop of a starting instance to succeed, got %d", w.Code)
+	}
+	// the start is done before the forced stop and must not win
+	instance, err := zone.GetInstance(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get instance: %v", err)
+	}
+	if instance.State != "stopped" {
+		t.Errorf("expected the instance to end up stopped, got %s", instance.State)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	s, c := newTestState(t)
+	zone := c.Zone(testZone)
+	id := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "stopped"})
+	alice, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	assign(t, s, id, "school", alice, db.PERMISSION_OPERATE)
+	start := func(key, body string) *httptest.ResponseRecorder {
+		return call(s.Idempotent(s.StartInstance), "POST", token, id, body, map[string]string{"Idempotency-Key": key})
+	}
+
+	first := start("k1", "")
+	if first.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", first.Code)
+	}
+	retry := start("k1", "")
+	if retry.Code != http.StatusAccepted || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("Idempotent-Replayed") != "true" || retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("expected the original response to be replayed, got %d %s", retry.Code, retry.Body)
+	}
+	var operations int
+	s.Pool.QueryRow(context.Background(), "select count(*) from operation").Scan(&operations)
+	if operations != 1 {
+		t.Errorf("expected the start to be requested once, got %d operations", operations)
+	}
+	if w := start("k1", `{"force": true}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a key reused for another request, got %d", w.Code)
+	}
+
+	if w := start("k2", ""); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for starting a started instance, got %d", w.Code)
+	}
+	if w := start("k2", ""); w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected a refusal to be replayed as well")
+	}
+}
+
+func TestSSHKeys(t *testing.T) {
+	s, c := newTestState(t)
+	zone := c.Zone(testZone)
+	_, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	w := call(s.GenerateSSHKey, "POST", token, "", `{"name": "laptop"}`, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	var generated struct {
+		db.SSHKey
+		PrivateKey string `json:"private_key"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &generated); err != nil {
+		t.Fatal(err)
+	}
+	if generated.Type != "ssh-ed25519" || !strings.Contains(generated.PrivateKey, "OPENSSH PRIVATE KEY") {
+		t.Errorf("expected an ed25519 key pair, got %s", w.Body)
+	}
+	if registered := zone.SSHKey(sshKeyName(&generated.SSHKey)); registered != generated.PublicKey {
+		t.Errorf("expected key to be registered, got %q", registered)
+	}
+
+	upload, _ := json.Marshal(map[string]string{"name": "desktop", "public_key": generated.PublicKey + " alice@desktop"})
+	if w := call(s.AddSSHKey, "POST", token, "", string(upload), nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a key uploaded twice, got %d", w.Code)
+	}
+	if w := call(s.AddSSHKey, "POST", token, "", `{"name": "desktop", "public_key": "ssh-dss AAAA"}`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid key, got %d", w.Code)
+	}
+	if w := call(s.GetSSHKeys, "GET", token, "", "", nil); !strings.Contains(w.Body.String(), `"name":"laptop"`) ||
+		strings.Contains(w.Body.String(), "private") {
+		t.Errorf("expected the generated key without private key, got %s", w.Body)
+	}
+
+	if w := call(s.DeleteSSHKey, "DELETE", token, fmt.Sprint(generated.Id), "", nil); w.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", w.Code)
+	}
+	if zone.SSHKey(sshKeyName(&generated.SSHKey)) != "" {
+		t.Errorf("expected key to be unregistered")
+	}
+	if w := call(s.DeleteSSHKey, "DELETE", token, fmt.Sprint(generated.Id), "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted key, got %d", w.Code)
+	}
+}
+
+func TestPreviewUserData(t *testing.T) {
+	s, _ := newTestState(t)
+	ctx := context.Background()
+	teacher, token := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, _ := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	addAccount(t, s, "m346", "bob", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{teacher, alice} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	preview := func(body string) *httptest.ResponseRecorder {
+		return call(s.PreviewUserData, "POST", token, fmt.Sprint(groupId), body, nil)
+	}
+	template := `#cloud-config\nusers: [{name: {{.Username}}}]\nwrite_files: [{path: /etc/motd, content: {{quote .Course}}}]\n`
+
+	w := preview(`{"user_data": "` + template + `", "username": "alice"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "name: alice") || !strings.Contains(w.Body.String(), `\"m346\"`) {
+		t.Errorf("expected user-data rendered for alice, got %d %s", w.Code, w.Body)
+	}
+	if w := preview(`{"user_data": "` + template + `"}`); !strings.Contains(w.Body.String(), "name: joe.doe") {
+		t.Errorf("expected user-data rendered for sample data, got %s", w.Body)
+	}
+	if w := preview(`{"user_data": "` + template + `", "username": "bob"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a non-member, got %d", w.Code)
+	}
+	if w := preview(`{"user_data": "#cloud-config\nhost: {{.Host}}"}`); w.Code != http.StatusUnprocessableEntity ||
+		!strings.Contains(w.Body.String(), "Host") {
+		t.Errorf("expected 422 with the reason for an unknown variable, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestBulkAction(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	teacher, token := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, other := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	bob, _ := addAccount(t, s, "m346", "bob", db.ROLE_STUDENT)
+	carol, _ := addAccount(t, s, "m346", "carol", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{teacher, alice, bob} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lesson := map[string]string{"lesson": "1"}
+	running := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "running", Labels: lesson})
+	stopped := zone.AddInstance(cloud.Instance{Name: "bob-linux", State: "stopped", Labels: lesson})
+	unlabeled := zone.AddInstance(cloud.Instance{Name: "bob-other", State: "running"})
+	outsider := zone.AddInstance(cloud.Instance{Name: "carol-linux", State: "running", Labels: lesson})
+	assign(t, s, running, "m346", alice, db.PERMISSION_OWNER)
+	assign(t, s, stopped, "m346", bob, db.PERMISSION_OWNER)
+	assign(t, s, unlabeled, "m346", bob, db.PERMISSION_OWNER)
+	assign(t, s, outsider, "m346", carol, db.PERMISSION_OWNER)
+	bulk := func(token, body string) *httptest.ResponseRecorder {
+		return call(s.BulkAction, "POST", token, fmt.Sprint(groupId), body, nil)
+	}
+
+	if w := bulk(other, `{"action": "stop"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a student, got %d", w.Code)
+	}
+	if w := bulk(token, `{"action": "delete"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown action, got %d", w.Code)
+	}
+	w := bulk(token, `{"action": "stop", "labels": {"lesson": "1"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var results []bulkResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bulkResult{
+		{InstanceId: running, Name: "alice-linux", Status: http.StatusAccepted, InstanceState: "stopping"},
+		{InstanceId: stopped, Name: "bob-linux", Status: http.StatusConflict, InstanceState: "stopped"},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected results for the labeled instances of the group, got %+v", results)
+	}
+	for i, result := range results {
+		result.OperationId = 0
+		if result != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], result)
+		}
+	}
+	var events int
+	s.Pool.QueryRow(ctx, "select count(*) from event_log where account_id = $1 and kind = $2 and info_val = $3",
+		teacher, db.INSTANCE_STOP, running).Scan(&events)
+	if events != 1 {
+		t.Errorf("expected the stop to be logged for the teacher, got %d events", events)
+	}
+	if instance, _ := zone.GetInstance(ctx, outsider); instance.State != "running" {
+		t.Errorf("expected the instance outside the group to keep running, was %s", instance.State)
+	}
+}
+
+func TestRedeemLoginLink(t *testing.T) {
+	s, _ := newTestState(t)
+	ctx := context.Background()
+	alice, _ := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	if err := db.SaveTenantSettings(ctx, s.Pool, &db.TenantSettings{Tenant: "school", LoginLinkEnabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte("secret-token"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addLink := func(expires string) int {
+		var id int
+		err := s.Pool.QueryRow(ctx, "insert into login_link (account_id, token, expires) values ($1, $2, now() + $3::interval) returning id",
+			alice, string(hashed), expires).Scan(&id)
+		if err != nil {
+			t.Fatalf("insert login link: %v", err)
+		}
+		return id
+	}
+	links := func() int {
+		var count int
+		s.Pool.QueryRow(ctx, "select count(*) from login_link").Scan(&count)
+		return count
+	}
+	redeem := func(id int, token string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]any{"id": id, "token": token})
+		w := httptest.NewRecorder()
+		s.RedeemLoginLink(w, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+		return w
+	}
+
+	link := addLink("15 minutes")
+	if w := redeem(link, "guessed"); w.Code != http.StatusUnauthorized || links() != 1 {
+		t.Errorf("expected 401 keeping the link for a wrong token, got %d with %d links", w.Code, links())
+	}
+	w := redeem(link, "secret-token")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var response authResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if subject, err := auth.ExtractSubject("Bearer " + response.Token); err != nil || subject != "alice" {
+		t.Errorf("expected a token for alice, got %q: %v", subject, err)
+	}
+	if w := redeem(link, "secret-token"); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for a link used before, got %d", w.Code)
+	}
+
+	expired := addLink("-1 minute")
+	if w := redeem(expired, "secret-token"); w.Code != http.StatusUnauthorized || links() != 0 {
+		t.Errorf("expected 401 deleting an expired link, got %d with %d links", w.Code, links())
+	}
+}
+
+func TestCreateInstanceLimit(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	teacher, teacherToken := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, token := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	_, outsider := addAccount(t, s, "m346", "bob", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{teacher, alice} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offeringId, err := db.InsertOffering(ctx, s.Pool, &db.Offering{
+		GroupId:      groupId,
+		Name:         "linux",
+		TemplateId:   zone.Templates[0].ID,
+		InstanceType: "standard.small",
+		DiskSize:     minDiskSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`{"offering_id": %d}`, offeringId)
+
+	if w := call(s.CreateInstance, "POST", outsider, "", body, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a non-member, got %d", w.Code)
+	}
+	w := call(s.CreateInstance, "POST", token, "", body, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	var instance cloud.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &instance); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(instance.Name, "alice-linux-") || instance.Labels["owner"] != "alice" {
+		t.Errorf("expected an instance named and labeled for alice, got %+v", instance)
+	}
+	if permission, err := db.LoadInstancePermission(ctx, s.Pool, instance.ID, alice); err != nil || !permission.Includes(db.PERMISSION_OWNER) {
+		t.Errorf("expected alice to own the instance, got %v: %v", permission, err)
+	}
+	if w := call(s.CreateInstance, "POST", token, "", body, nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 beyond the limit of the group, got %d", w.Code)
+	}
+	var reservations int
+	s.Pool.QueryRow(ctx, "select count(*) from account_reservation").Scan(&reservations)
+	if reservations != 0 {
+		t.Errorf("expected the reservations to be released, got %d", reservations)
+	}
+
+	for range 2 {
+		if w := call(s.CreateInstance, "POST", teacherToken, "", body, nil); w.Code != http.StatusCreated {
+			t.Errorf("expected teachers not to be limited, got %d", w.Code)
+		}
+	}
+}
+
+func TestDeleteInstance(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	id := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "running"})
+	teacher, token := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, other := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []int{teacher, alice} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assign(t, s, id, "m346", alice, db.PERMISSION_OWNER)
+	remove := func(token, query string) *httptest.ResponseRecorder {
+		return callTarget(s.DeleteInstance, "DELETE", "/?"+query, token, id, "", nil)
+	}
+
+	if w := remove(other, "confirm=alice-linux"); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an owner whose offering does not allow deletion, got %d", w.Code)
+	}
+	if w := remove(token, "confirm=bob-linux"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a deletion confirmed by another name, got %d", w.Code)
+	}
+	if w := remove(token, "confirm=alice-linux&snapshot=true"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a snapshot to be kept, got %d", w.Code)
+	}
+	if w := remove(token, "confirm=alice-linux"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	if instance, _ := zone.GetInstance(ctx, id); instance.State != "stopped" {
+		t.Errorf("expected the instance to be stopped, was %s", instance.State)
+	}
+	if w := remove(token, "confirm=alice-linux"); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 for an instance pending deletion, got %d", w.Code)
+	}
+	if w := call(s.RestoreInstance, "POST", token, id, "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for restoring the instance, got %d", w.Code)
+	}
+	if w := call(s.RestoreInstance, "POST", token, id, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an instance not pending deletion, got %d", w.Code)
+	}
+
+	s.Config.DeletionGracePeriod = 0
+	if w := remove(token, "confirm=alice-linux"); w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	s.processDueDeletions(ctx)
+	if _, err := zone.GetInstance(ctx, id); !errors.Is(err, cloud.ErrNotFound) {
+		t.Errorf("expected the instance to be destroyed, got %v", err)
+	}
+	var assignments int
+	s.Pool.QueryRow(ctx, "select count(*) from instance_assignment where instance_id = $1", id).Scan(&assignments)
+	if assignments != 0 {
+		t.Errorf("expected the assignments to be removed, got %d", assignments)
+	}
+}
+
+func TestSnapshotLimit(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	id := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "running"})
+	teacher, teacherToken := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, token := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []int{teacher, alice} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetGroupMaxSnapshots(ctx, s.Pool, groupId, 1); err != nil {
+		t.Fatal(err)
+	}
+	assign(t, s, id, "m346", alice, db.PERMISSION_OWNER)
+
+	if w := call(s.CreateSnapshot, "POST", token, id, `{"name": ""}`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a snapshot without name, got %d", w.Code)
+	}
+	if w := call(s.CreateSnapshot, "POST", token, id, `{"name": "before-lab"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", w.Code)
+	}
+	if w := call(s.CreateSnapshot, "POST", token, id, `{"name": "after-lab"}`, nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 beyond the limit of the group, got %d", w.Code)
+	}
+	if w := call(s.CreateSnapshot, "POST", teacherToken, id, `{"name": "graded"}`, nil); w.Code != http.StatusCreated {
+		t.Errorf("expected teachers not to be limited, got %d", w.Code)
+	}
+	var reservations int
+	s.Pool.QueryRow(ctx, "select count(*) from account_reservation").Scan(&reservations)
+	if reservations != 0 {
+		t.Errorf("expected the reservations to be released, got %d", reservations)
+	}
+
+	w := call(s.GetSnapshots, "GET", token, id, "", nil)
+	var snapshots []cloud.Snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshots); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != "before-lab" || snapshots[1].Name != "graded" {
+		t.Errorf("expected the snapshots by the names given, got %s", w.Body)
+	}
+	var events int
+	s.Pool.QueryRow(ctx, "select count(*) from event_log where account_id = $1 and kind = $2", alice, db.INSTANCE_SNAPSHOT).Scan(&events)
+	if events != 1 {
+		t.Errorf("expected the snapshot to be logged for alice, got %d events", events)
+	}
+}
+
+func TestResetInstance(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	template := zone.Templates[0].ID
+	id := zone.AddInstance(cloud.Instance{Name: "alice-linux", State: "running", Template: &cloud.Reference{ID: template}})
+	other := zone.AddInstance(cloud.Instance{Name: "alice-other", State: "running", Template: &cloud.Reference{ID: template}})
+	alice, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	assign(t, s, id, "school", alice, db.PERMISSION_OWNER)
+	assign(t, s, other, "school", alice, db.PERMISSION_OWNER)
+	confirmation := func(id string) string {
+		w := call(s.RequestResetConfirmation, "POST", token, id, "", nil)
+		var response struct {
+			ConfirmationToken string `json:"confirmation_token"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("expected a confirmation token, got %d %s", w.Code, w.Body)
+		}
+		return response.ConfirmationToken
+	}
+	reset := func(confirmationToken string) *httptest.ResponseRecorder {
+		return call(s.ResetInstance, "POST", token, id, `{"confirmation_token": "`+confirmationToken+`"}`, nil)
+	}
+
+	if w := reset(""); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for an unconfirmed reset, got %d", w.Code)
+	}
+	if w := reset(confirmation(other)); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 for a reset confirmed for another instance, got %d", w.Code)
+	}
+	w := reset(confirmation(id))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"template_id":"`+template+`"`) ||
+		!strings.Contains(w.Body.String(), `"instance_state":"running"`) {
+		t.Fatalf("expected 200 with the template reinstalled, got %d %s", w.Code, w.Body)
+	}
+	var events int
+	s.Pool.QueryRow(ctx, "select count(*) from event_log where account_id = $1 and kind = $2 and info_val = $3",
+		alice, db.INSTANCE_RESET, id).Scan(&events)
+	if events != 1 {
+		t.Errorf("expected the reset to be logged, got %d events", events)
+	}
+}
+
+func TestRevealInstancePassword(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	id := zone.AddInstance(cloud.Instance{Name: "alice-windows", State: "stopped"})
+	alice, token := addAccount(t, s, "school", "alice", db.ROLE_STUDENT)
+	assign(t, s, id, "school", alice, db.PERMISSION_OWNER)
+	reveal := func() *httptest.ResponseRecorder {
+		return call(s.RevealInstancePassword, "POST", token, id, "", nil)
+	}
+
+	if w := reveal(); w.Code != http.StatusGone {
+		t.Errorf("expected 410 before the password was reset, got %d", w.Code)
+	}
+	if w := call(s.ResetInstancePassword, "POST", token, id, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	w := reveal()
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || response["password"] == "" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected the password not to be cached, got %d %s", w.Code, w.Body)
+	}
+	if w := reveal(); w.Code != http.StatusGone {
+		t.Errorf("expected 410 for a password revealed before, got %d", w.Code)
+	}
+	var events int
+	s.Pool.QueryRow(ctx, "select count(*) from event_log where account_id = $1 and kind = $2", alice, db.PASSWORD_REVEALED).Scan(&events)
+	if events != 1 {
+		t.Errorf("expected the reveal to be logged once, got %d events", events)
+	}
+
+	if err := db.SaveTenantSettings(ctx, s.Pool, &db.TenantSettings{Tenant: "school", ExamMode: true}); err != nil {
+		t.Fatal(err)
+	}
+	if w := call(s.ResetInstancePassword, "POST", token, id, "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", w.Code)
+	}
+	if w := reveal(); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 in exam mode, got %d", w.Code)
+	}
+}
+
+func TestDecideResize(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	id := zone.AddInstance(cloud.Instance{Name: "alice-docker", State: "stopped", InstanceType: &cloud.Reference{Name: "standard.small"}})
+	teacher, token := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, other := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []int{teacher, alice} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.AllowInstanceType(ctx, s.Pool, &db.AllowedInstanceType{GroupId: groupId, InstanceType: "standard.medium", RequiresApproval: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assign(t, s, id, "m346", alice, db.PERMISSION_OWNER)
+	request := func() string {
+		w := call(s.ResizeInstance, "POST", other, id, `{"instance_type": "standard.medium"}`, nil)
+		var resize db.Resize
+		if err := json.Unmarshal(w.Body.Bytes(), &resize); err != nil || w.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 with the queued resize, got %d %s", w.Code, w.Body)
+		}
+		return fmt.Sprint(resize.Id)
+	}
+	instanceType := func() string {
+		instance, err := zone.GetInstance(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return instance.InstanceType.Name
+	}
+
+	if w := call(s.ResizeInstance, "POST", other, id, `{"instance_type": "standard.large"}`, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a type not allowed, got %d", w.Code)
+	}
+	denied := request()
+	if w := call(s.ApproveResize, "POST", other, denied, "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a student deciding, got %d", w.Code)
+	}
+	if w := call(s.DenyResize, "POST", token, denied, "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for denying, got %d", w.Code)
+	}
+	if w := call(s.ApproveResize, "POST", token, denied, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for approving a denied resize, got %d", w.Code)
+	}
+	if instanceType() != "standard.small" {
+		t.Errorf("expected the denied resize not to be carried out")
+	}
+
+	approved := request()
+	zone.Fail("ScaleInstance", cloud.ErrUnavailable)
+	if w := call(s.ApproveResize, "POST", token, approved, "", nil); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while the provider is unavailable, got %d", w.Code)
+	}
+	if w := call(s.GetPendingResizes, "GET", token, "", "", nil); !strings.Contains(w.Body.String(), `"id":`+approved+`,`) {
+		t.Errorf("expected the resize that failed to be pending again, got %s", w.Body)
+	}
+	zone.Fail("ScaleInstance", nil)
+	if w := call(s.ApproveResize, "POST", token, approved, "", nil); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for approving, got %d", w.Code)
+	}
+	if instanceType() != "standard.medium" {
+		t.Errorf("expected the instance to be resized, was %s", instanceType())
+	}
+	if w := call(s.DenyResize, "POST", token, approved, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for denying an approved resize, got %d", w.Code)
+	}
+}
+
+func TestApproveIngressRule(t *testing.T) {
+	s, c := newTestState(t)
+	ctx := context.Background()
+	zone := c.Zone(testZone)
+	id := zone.AddInstance(cloud.Instance{Name: "alice-web", State: "running"})
+	teacher, token := addAccount(t, s, "m346", "tina", db.ROLE_TEACHER)
+	alice, other := addAccount(t, s, "m346", "alice", db.ROLE_STUDENT)
+	groupId, err := db.EnsureGroup(ctx, s.Pool, "team-a", "m346")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range []int{teacher, alice} {
+		if err := db.AddGroupMember(ctx, s.Pool, groupId, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assign(t, s, id, "m346", alice, db.PERMISSION_OWNER)
+
+	if w := call(s.RequestIngressRule, "POST", other, id, `{"protocol": "icmp", "port": 8080}`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid protocol, got %d", w.Code)
+	}
+	w := call(s.RequestIngressRule, "POST", other, id, `{"protocol": "tcp", "port": 8080}`, nil)
+	var rule db.IngressRule
+	if err := json.Unmarshal(w.Body.Bytes(), &rule); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 with the pending rule, got %d %s", w.Code, w.Body)
+	}
+	ruleId := fmt.Sprint(rule.Id)
+	if w := call(s.ApproveIngressRule, "POST", other, ruleId, "", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a student deciding, got %d", w.Code)
+	}
+	release, err := db.TryLockInstance(ctx, s.Pool, id)
+	if err != nil || release == nil {
+		t.Fatalf("lock instance: %v", err)
+	}
+	if w := call(s.ApproveIngressRule, "POST", token, ruleId, "", nil); w.Code != http.StatusConflict {
+		t.Errorf("expected 409 while another action holds the lock, got %d", w.Code)
+	}
+	release()
+	if w := call(s.ApproveIngressRule, "POST", token, ruleId, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	instance, err := zone.GetInstance(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(instance.SecurityGroups, func(group cloud.Reference) bool { return group.Name == "cloud-castle-"+id }) {
+		t.Errorf("expected the security group of the instance to be attached, got %+v", instance.SecurityGroups)
+	}
+	if w := call(s.ApproveIngressRule, "POST", token, ruleId, "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a rule approved before, got %d", w.Code)
+	}
+	if w := call(s.GetIngressRules, "GET", other, id, "", nil); !strings.Contains(w.Body.String(), `"status":"approved"`) {
+		t.Errorf("expected the rule to be listed as approved, got %s", w.Body)
+	}
+}
@@ -0,0 +1,158 @@
+package endpoints
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/auth"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// maxIdempotencyKeyLength is the length of the longest idempotency key
+// accepted, as stored in the database.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyProcessingTimeout is how long a request made with an idempotency
+// key may take before retries carry it out once more, assuming that the
+// backend died while processing it.
+const idempotencyProcessingTimeout = 10 * time.Minute
+
+// Idempotent makes the handler replay its original response if a request is
+// retried with the same Idempotency-Key header, instead of carrying out the
+// action once more. Keys are scoped to the caller and kept for the configured
+// TTL. Reusing a key for another request is refused with 422, retrying a
+// request still being processed with 409, unless the processing timed out.
+// Responses with a server error or a panic are not kept, so that the request
+// can be retried. Requests without the header are handled as usual.
+func (s *Stateful) Idempotent(handler auth.Handler) auth.Handler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handler(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			fmt.Fprintf(os.Stderr, "idempotency key longer than %d characters\n", maxIdempotencyKeyLength)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		account := s.getAccount(w, r)
+		if account == nil {
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read body: %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+		previous, err := db.ClaimIdempotencyKey(r.Context(), s.Pool, account.Id, key, fingerprint,
+			s.Config.IdempotencyKeyTTL, idempotencyProcessingTimeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if previous != nil {
+			replayResponse(w, key, fingerprint, previous)
+			return
+		}
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			// net/http recovers from the panic, the key must not stay claimed
+			if recovered := recover(); recovered != nil {
+				if err := db.ReleaseIdempotencyKey(context.WithoutCancel(r.Context()), s.Pool, account.Id, key); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				panic(recovered)
+			}
+		}()
+		handler(recorder, r)
+		// the caller may be gone, the outcome has to be recorded for its retry
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.status >= http.StatusInternalServerError {
+			err = db.ReleaseIdempotencyKey(ctx, s.Pool, account.Id, key)
+		} else {
+			var location *string
+			if value := w.Header().Get("Location"); value != "" {
+				location = &value
+			}
+			err = db.StoreIdempotentResponse(ctx, s.Pool, account.Id, key, recorder.status, location, recorder.body.Bytes())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// replayResponse writes the response to the request made with the key before,
+// if it matches the current request and has been responded to.
+func replayResponse(w http.ResponseWriter, key, fingerprint string, previous *db.IdempotentRequest) {
+	if previous.Fingerprint != fingerprint {
+		fmt.Fprintf(os.Stderr, "idempotency key %s reused for another request\n", key)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	if previous.Status == nil {
+		fmt.Fprintf(os.Stderr, "request with idempotency key %s still being processed\n", key)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if previous.Location != nil {
+		w.Header().Set("Location", *previous.Location)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(*previous.Status)
+	w.Write(previous.Body)
+}
+
+// requestFingerprint identifies the request by its method, URL and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response on while keeping its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// RunIdempotencyKeyPurge discards expired idempotency keys, checking every
+// interval until the context is done.
+func (s *Stateful) RunIdempotencyKeyPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := db.DiscardExpiredIdempotencyKeys(ctx, s.Pool, s.Config.IdempotencyKeyTTL); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/auth"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/composed-ch/cloud-castle-backend/internal/mailing"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RequestLoginLink emails a single-use login link to the given address, if the
+// tenant of the account allows passwordless login. The responses are the same
+// as for ResetPassword, so that the endpoint cannot be used to probe accounts.
+func (s *Stateful) RequestLoginLink(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Email string `json:"email"`
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal login link request body: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	accountId, ok := s.accountForTokenRequest(w, r, "login_link", payload.Email)
+	if !ok {
+		return
+	}
+	account, err := db.LoadAccountById(r.Context(), s.Pool, accountId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account for login link: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	settings, err := db.LoadTenantSettings(r.Context(), s.Pool, account.Tenant)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load tenant settings for login link: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !settings.LoginLinkEnabled {
+		fmt.Fprintf(os.Stderr, "login links are disabled for tenant %s, not sending one to %s\n", account.Tenant, payload.Email)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	token, err := auth.RandomPasswordAlnum(64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate random password: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	hashedToken, err := bcrypt.GenerateFromPassword([]byte(token), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bcrypt token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// links that expired unused are discarded along the way
+	if _, err := s.Pool.Exec(r.Context(), "delete from login_link where expires < now()"); err != nil {
+		fmt.Fprintf(os.Stderr, "delete expired login links: %v\n", err)
+	}
+	var linkId int
+	err = s.Pool.QueryRow(r.Context(),
+		"insert into login_link (account_id, token) values ($1, $2) returning id",
+		accountId, hashedToken).Scan(&linkId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "insert login link token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	message := mailing.CreateLoginLinkEmail(linkId, payload.Email, token)
+	err = mailing.SendPostmarkEmail(
+		"info@cloud-castle.ch",
+		payload.Email,
+		"Cloud Castle Anmeldelink",
+		message,
+		"cloud-castle-login-link",
+		s.Config.PostmarkToken,
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "send login link email to %s: %v\n", payload.Email, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.LOGIN_LINK_SENT, accountId, "email", payload.Email)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// RedeemLoginLink consumes the login link identified by its id and token and
+// issues a regular token for the account it was sent to.
+func (s *Stateful) RedeemLoginLink(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Id    int    `json:"id"`
+		Token string `json:"token"`
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal login link redemption body: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// the link is only consumed once its token matched, so that guessing ids
+	// does not invalidate the links of others
+	tx, err := s.Pool.Begin(r.Context())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "begin login link redemption: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	var accountId int
+	var hashedToken string
+	var expires time.Time
+	err = tx.QueryRow(r.Context(),
+		"select account_id, token, expires from login_link where id = $1 for update",
+		payload.Id).Scan(&accountId, &hashedToken, &expires)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			fmt.Fprintf(os.Stderr, "load login link %d: %v\n", payload.Id, err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if expires.Before(time.Now()) {
+		fmt.Fprintf(os.Stderr, "the login link with id %d expired at %v\n", payload.Id, expires)
+		if _, err := tx.Exec(r.Context(), "delete from login_link where id = $1", payload.Id); err != nil {
+			fmt.Fprintf(os.Stderr, "delete expired login link %d: %v\n", payload.Id, err)
+		} else if err := tx.Commit(r.Context()); err != nil {
+			fmt.Fprintf(os.Stderr, "delete expired login link %d: %v\n", payload.Id, err)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err = bcrypt.CompareHashAndPassword([]byte(hashedToken), []byte(payload.Token)); err != nil {
+		fmt.Fprintf(os.Stderr, "the token provided does not match the login link %d: %v\n", payload.Id, err)
+		db.LogEvent(r.Context(), s.Pool, db.LOGIN_FAILURE, accountId, "login_link", fmt.Sprint(payload.Id))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if _, err := tx.Exec(r.Context(), "delete from login_link where id = $1", payload.Id); err != nil {
+		fmt.Fprintf(os.Stderr, "consume login link %d: %v\n", payload.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		fmt.Fprintf(os.Stderr, "consume login link %d: %v\n", payload.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	account, err := db.LoadAccountById(r.Context(), s.Pool, accountId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account for login link: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	settings, err := db.LoadTenantSettings(r.Context(), s.Pool, account.Tenant)
+	if err != nil || !settings.LoginLinkEnabled {
+		fmt.Fprintf(os.Stderr, "login links are not enabled for tenant %s: %v\n", account.Tenant, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	tokenStr, err := auth.IssueToken(account.Name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if tokenData, err := json.Marshal(authResponse{Token: tokenStr}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	} else {
+		db.LogEvent(r.Context(), s.Pool, db.LOGIN_LINK_USED, accountId, "username", account.Name)
+		w.Write(tokenData)
+	}
+}
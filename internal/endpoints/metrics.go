@@ -0,0 +1,34 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/exoscale"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// GetMetrics reports how instance listings were served since the start, so
+// that teachers can tell whether the cache takes load off the Exoscale API.
+func (s *Stateful) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		InstanceCache exoscale.CacheStats `json:"instance_cache"`
+	}
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	if account.Role != db.ROLE_TEACHER {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	payload, err := json.Marshal(Payload{InstanceCache: exoscale.InstanceCacheStats()})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal metrics payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
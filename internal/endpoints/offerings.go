@@ -0,0 +1,337 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/auth"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/composed-ch/cloud-castle-backend/internal/userdata"
+)
+
+const (
+	minDiskSize          = 10
+	instanceSuffixLength = 4
+)
+
+var (
+	instanceTypeName = regexp.MustCompile(`^[a-z0-9]+\.[a-z-]+$`)
+	invalidHostChars = regexp.MustCompile(`[^a-z0-9-]+`)
+)
+
+// GetOfferings lists the offerings of all groups the caller is a member of.
+func (s *Stateful) GetOfferings(w http.ResponseWriter, r *http.Request) {
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	offerings, err := db.LoadOfferingsForAccount(r.Context(), s.Pool, account.Id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load offerings: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(offerings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal offerings payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// CreateOffering adds an offering to the group given by the id path value.
+// Only teachers who are members of the group may do so.
+func (s *Stateful) CreateOffering(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	account := s.getGroupTeacher(w, r, groupId)
+	if account == nil {
+		return
+	}
+	offering, err := jsonBody[db.Offering](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal offering: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	offering.GroupId = groupId
+	if offering.DiskSize == 0 {
+		offering.DiskSize = minDiskSize
+	}
+	if offering.Name == "" || offering.TemplateId == "" || !instanceTypeName.MatchString(offering.InstanceType) ||
+		offering.DiskSize < minDiskSize {
+		fmt.Fprintf(os.Stderr, "invalid offering: %+v\n", offering)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := userdata.Validate(offering.UserData); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid user-data of offering %s: %v\n", offering.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	api := s.getAPIAccess(w, r)
+	if api == nil || !s.validInstanceType(w, r, api, offering.InstanceType) {
+		return
+	}
+	template, err := s.Catalog.FindTemplate(r.Context(), api, offering.TemplateId)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if template == nil {
+		fmt.Fprintf(os.Stderr, "template %s not available to tenant %s\n", offering.TemplateId, account.Tenant)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	offering.Id, err = db.InsertOffering(r.Context(), s.Pool, offering)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.OFFERING_CREATED, account.Id, "offering", strconv.Itoa(offering.Id))
+	payload, err := json.Marshal(offering)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal offering payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(payload)
+}
+
+// DeleteOffering removes the offering given by the id path value. Instances
+// created from it are kept.
+func (s *Stateful) DeleteOffering(w http.ResponseWriter, r *http.Request) {
+	offeringId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	offering, err := db.LoadOffering(r.Context(), s.Pool, offeringId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	account := s.getGroupTeacher(w, r, offering.GroupId)
+	if account == nil {
+		return
+	}
+	if err := db.DeleteOffering(r.Context(), s.Pool, offeringId); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.OFFERING_DELETED, account.Id, "offering", strconv.Itoa(offeringId))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateInstance creates an instance from one of the caller's offerings and
+// makes the caller its owner, unless the caller already owns as many
+// instances as the offering's group allows. The instance is created in the
+// zone given, or in the default zone of the caller's tenant.
+func (s *Stateful) CreateInstance(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		OfferingId int    `json:"offering_id"`
+		Name       string `json:"name"`
+		Zone       string `json:"zone"`
+	}
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal instance creation request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if payload.Zone != "" && payload.Zone != api.Zone {
+		if api = s.accessInZone(w, account.Name, payload.Zone); api == nil {
+			return
+		}
+	}
+	offering, err := db.LoadOffering(r.Context(), s.Pool, payload.OfferingId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	member, err := db.IsGroupMember(r.Context(), s.Pool, offering.GroupId, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		fmt.Fprintf(os.Stderr, "account %s is not a member of the group of offering %d\n", account.Name, offering.Id)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if account.Role != db.ROLE_TEACHER {
+		group, err := db.LoadGroupById(r.Context(), s.Pool, offering.GroupId)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// counted until the instance is assigned, so that parallel requests
+		// respect the limit
+		reservation, err := db.ReserveInstance(r.Context(), s.Pool, account.Id, group.MaxInstances)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if reservation == 0 {
+			fmt.Fprintf(os.Stderr, "account %s already owns %d instances\n", account.Name, group.MaxInstances)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		defer s.releaseReservation(r.Context(), reservation)
+	}
+	name, err := instanceName(account.Name, payload.Name, offering.Name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	userData, err := s.renderUserData(r.Context(), offering, account, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "render user-data of offering %d for account %s: %v\n", offering.Id, account.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sshKeys, err := s.ensureSSHKeys(r.Context(), api, account.Id)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	instance, err := api.CreateInstance(r.Context(), cloud.InstanceSpec{
+		Name:           name,
+		TemplateID:     offering.TemplateId,
+		InstanceType:   offering.InstanceType,
+		DiskSize:       int64(offering.DiskSize),
+		SecurityGroups: offering.SecurityGroups,
+		UserData:       userData,
+		SSHKeys:        sshKeys,
+		Labels: map[string]string{
+			"owner":    account.Name,
+			"offering": strconv.Itoa(offering.Id),
+		},
+	})
+	if err != nil {
+		writeProviderError(w, fmt.Errorf("create instance from offering %d: %w", offering.Id, err))
+		return
+	}
+	err = db.AssignOfferedInstance(r.Context(), s.Pool, instance.ID, api.Zone, account.Tenant, account.Id, offering.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		// an instance without an owner would run unnoticed
+		if err := api.DeleteInstance(context.WithoutCancel(r.Context()), instance.ID); err != nil {
+			fmt.Fprintf(os.Stderr, "delete unassigned instance %s: %v\n", instance.ID, err)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.states.assignmentsChanged()
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_CREATED, account.Id, "instance", instance.ID)
+	data, err := json.Marshal(instance)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal instance payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+// releaseReservation gives up the reservation once what it was for has been
+// recorded or failed to be created.
+func (s *Stateful) releaseReservation(ctx context.Context, id int) {
+	if err := db.ReleaseReservation(context.WithoutCancel(ctx), s.Pool, id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// accessInZone returns API access to the zone, if the user's tenant has a key
+// for it. Otherwise, an error status is written.
+func (s *Stateful) accessInZone(w http.ResponseWriter, username, zone string) *cloud.Access {
+	accesses, err := s.GetAPIAccesses(username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get API access: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	for _, api := range accesses {
+		if api.Zone == zone {
+			return api
+		}
+	}
+	fmt.Fprintf(os.Stderr, "user %s has no API key for zone %s\n", username, zone)
+	w.WriteHeader(http.StatusBadRequest)
+	return nil
+}
+
+// getGroupTeacher returns the account of the caller, if the caller is a
+// teacher and a member of the given group. Otherwise, an error status is
+// written.
+func (s *Stateful) getGroupTeacher(w http.ResponseWriter, r *http.Request, groupId int) *db.Account {
+	account := s.getAccount(w, r)
+	if account == nil {
+		return nil
+	}
+	if account.Role != db.ROLE_TEACHER {
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+	member, err := db.IsGroupMember(r.Context(), s.Pool, groupId, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+	if !member {
+		fmt.Fprintf(os.Stderr, "teacher %s is not a member of group %d\n", account.Name, groupId)
+		w.WriteHeader(http.StatusForbidden)
+		return nil
+	}
+	return account
+}
+
+// instanceName derives a host name for a new instance from the username and
+// the requested name (or the offering name, if none was requested), followed
+// by a random suffix.
+func instanceName(username, requested, offering string) (string, error) {
+	if requested == "" {
+		requested = offering
+	}
+	name := invalidHostChars.ReplaceAllString(strings.ToLower(username+"-"+requested), "-")
+	suffix, err := auth.RandomPasswordAlnum(instanceSuffixLength)
+	if err != nil {
+		return "", fmt.Errorf("generate instance name suffix: %v", err)
+	}
+	name = strings.Trim(name, "-")
+	if len(name) > 58 {
+		name = name[:58]
+	}
+	return name + "-" + strings.ToLower(suffix), nil
+}
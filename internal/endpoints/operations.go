@@ -0,0 +1,126 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// operationTimeout is how long a provider operation is tracked before it is
+// considered failed.
+const operationTimeout = 10 * time.Minute
+
+// GetOperation returns the operation given by the id path value, if the caller
+// requested it or teaches its instance.
+func (s *Stateful) GetOperation(w http.ResponseWriter, r *http.Request) {
+	operationId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	operation, err := db.LoadOperation(r.Context(), s.Pool, operationId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if operation == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if operation.AccountId != account.Id {
+		teacher, err := db.IsTeacherOfInstance(r.Context(), s.Pool, operation.InstanceId, account.Id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !teacher {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	payload, err := json.Marshal(operation)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal operation payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// operationLease is how long a replica of the backend may track an operation
+// it claimed before others may claim it, which is longer than tracking takes.
+const operationLease = operationTimeout + time.Minute
+
+// ResumeOperations tracks the operations left pending, e.g. by a restart,
+// checking every interval until the context is done.
+func (s *Stateful) ResumeOperations(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.resumePendingOperations(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Stateful) resumePendingOperations(ctx context.Context) {
+	operations, err := db.ClaimPendingOperations(ctx, s.Pool, operationLease)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	for _, operation := range operations {
+		api, err := s.GetAPIAccessForTenantInZone(operation.Tenant, operation.Zone)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		s.trackOperation(api, operation)
+	}
+}
+
+// trackOperation waits in the background for the provider operation and
+// records its outcome. If the server shuts down first, the operation is
+// released to be resumed by another replica.
+func (s *Stateful) trackOperation(api *cloud.Access, operation *db.Operation) {
+	s.tracking.Add(1)
+	go func() {
+		defer s.tracking.Done()
+		failure := api.WaitForOperation(s.ctx, operation.ProviderId, operationTimeout)
+		if s.ctx.Err() != nil {
+			if err := db.ReleaseOperation(context.Background(), s.Pool, operation.Id); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			return
+		}
+		if failure != nil {
+			fmt.Fprintf(os.Stderr, "operation %d (%s on instance %s) failed: %v\n",
+				operation.Id, operation.Kind, operation.InstanceId, failure)
+		}
+		if err := db.FinishOperation(context.Background(), s.Pool, operation.Id, failure); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+}
+
+// Wait waits for the operations tracked in the background to be recorded or
+// released, which they are once the context of the Stateful is done.
+func (s *Stateful) Wait() {
+	s.tracking.Wait()
+}
@@ -0,0 +1,87 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// ResetInstancePassword has Exoscale generate a new password for the default
+// user of the instance given by the id path value, which the owner can then
+// reveal once.
+func (s *Stateful) ResetInstancePassword(w http.ResponseWriter, r *http.Request) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	_, release, ok := s.beginTransition(w, r, api, id, db.INSTANCE_PASSWORD)
+	if !ok {
+		return
+	}
+	defer release()
+	if err := api.ResetInstancePassword(r.Context(), id); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if err := db.InsertPasswordReset(r.Context(), s.Pool, id, account.Id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_PASSWORD, account.Id, "instance", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevealInstancePassword returns the password generated by the latest
+// password reset of the instance given by the id path value. The password is
+// only revealed once, and not at all while the tenant is in exam mode.
+func (s *Stateful) RevealInstancePassword(w http.ResponseWriter, r *http.Request) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	settings, err := db.LoadTenantSettings(r.Context(), s.Pool, account.Tenant)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if settings.ExamMode {
+		fmt.Fprintf(os.Stderr, "tenant %s is in exam mode, not revealing password of instance %s\n", account.Tenant, id)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	claimed, err := db.ClaimPasswordReveal(r.Context(), s.Pool, id, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !claimed {
+		fmt.Fprintf(os.Stderr, "password of instance %s already revealed or never reset\n", id)
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	password, err := api.RevealInstancePassword(r.Context(), id)
+	if err != nil {
+		if err := db.ReleasePasswordReveal(r.Context(), s.Pool, id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		writeProviderError(w, err)
+		return
+	}
+	payload, err := json.Marshal(map[string]string{"password": password})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal password payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.PASSWORD_REVEALED, account.Id, "instance", id)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(payload)
+}
@@ -0,0 +1,138 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/auth"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+const resetAction = "reset"
+
+// RequestResetConfirmation issues the token the caller has to pass to
+// ResetInstance in order to confirm the reinstallation of the instance given
+// by the id path value.
+func (s *Stateful) RequestResetConfirmation(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		ConfirmationToken string    `json:"confirmation_token"`
+		Expires           time.Time `json:"expires"`
+	}
+	_, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	token, expires, err := auth.IssueConfirmationToken(account.Name, resetAction, r.PathValue("id"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issue confirmation token: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(Response{ConfirmationToken: token, Expires: expires})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal confirmation payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// ResetInstance reinstalls the instance given by the id path value from the
+// template of the offering it was created from, or from its current template,
+// keeping its ID and IP address. The user-data of the offering is rendered
+// anew for the student who created the instance.
+func (s *Stateful) ResetInstance(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		ConfirmationToken string `json:"confirmation_token"`
+	}
+	type Response struct {
+		TemplateId    string `json:"template_id"`
+		InstanceState string `json:"instance_state"`
+	}
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal reset request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := auth.VerifyConfirmationToken(payload.ConfirmationToken, account.Name, resetAction, id); err != nil {
+		fmt.Fprintf(os.Stderr, "reset of instance %s not confirmed: %v\n", id, err)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if deletion, err := db.LoadDeletion(r.Context(), s.Pool, id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if deletion != nil {
+		fmt.Fprintf(os.Stderr, "instance %s is pending deletion, refusing reset\n", id)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	state, release, ok := s.beginTransition(w, r, api, id, db.INSTANCE_RESET)
+	if !ok {
+		return
+	}
+	defer release()
+	var templateId, userData string
+	offering, err := db.LoadInstanceOffering(r.Context(), s.Pool, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if offering != nil {
+		templateId = offering.TemplateId
+		if userData, err = s.offeredUserData(r.Context(), api, offering, id); err != nil {
+			fmt.Fprintf(os.Stderr, "render user-data of offering %d for instance %s: %v\n", offering.Id, id, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	templateId, err = api.ResetInstance(r.Context(), id, templateId, userData)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if err := db.InsertReset(r.Context(), s.Pool, id, account.Id, templateId); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_RESET, account.Id, "instance", id)
+	data, err := json.Marshal(Response{TemplateId: templateId, InstanceState: state})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal reset payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(data)
+}
+
+// offeredUserData renders the user-data of the offering the instance was
+// created from for the account that created it.
+func (s *Stateful) offeredUserData(ctx context.Context, api *cloud.Access, offering *db.Offering, id string) (string, error) {
+	if offering.UserData == "" {
+		return "", nil
+	}
+	ownerId, err := db.LoadOfferedInstanceOwner(ctx, s.Pool, id)
+	if err != nil {
+		return "", err
+	}
+	owner, err := db.LoadAccountById(ctx, s.Pool, ownerId)
+	if err != nil {
+		return "", err
+	}
+	instance, err := api.GetInstance(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return s.renderUserData(ctx, offering, owner, instance.Name)
+}
@@ -0,0 +1,347 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// GetInstanceTypes lists the instance types the members of the group given by
+// the id path value may resize their instances to.
+func (s *Stateful) GetInstanceTypes(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	member, err := db.IsGroupMember(r.Context(), s.Pool, groupId, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !member {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	types, err := db.LoadAllowedInstanceTypes(r.Context(), s.Pool, groupId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(types)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal instance types payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// AllowInstanceType lets the members of the group given by the id path value
+// resize their instances to an instance type, optionally only with approval.
+// Only teachers who are members of the group may do so.
+func (s *Stateful) AllowInstanceType(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if account := s.getGroupTeacher(w, r, groupId); account == nil {
+		return
+	}
+	allowed, err := jsonBody[db.AllowedInstanceType](r)
+	if err != nil || !instanceTypeName.MatchString(allowed.InstanceType) {
+		fmt.Fprintf(os.Stderr, "invalid instance type: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	api := s.getAPIAccess(w, r)
+	if api == nil || !s.validInstanceType(w, r, api, allowed.InstanceType) {
+		return
+	}
+	allowed.GroupId = groupId
+	if err := db.AllowInstanceType(r.Context(), s.Pool, allowed); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DisallowInstanceType removes the instance type given by the type path value
+// from the types allowed for the group given by the id path value.
+func (s *Stateful) DisallowInstanceType(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if account := s.getGroupTeacher(w, r, groupId); account == nil {
+		return
+	}
+	removed, err := db.DisallowInstanceType(r.Context(), s.Pool, groupId, r.PathValue("type"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResizeInstance changes the type of the stopped instance given by the id path
+// value. Students may only choose types allowed for one of their groups; if
+// those require approval, the resize is queued for a teacher to decide and
+// 202 is returned. Queued resizes may be requested in any state, as the
+// instance only has to be stopped once the resize is approved.
+func (s *Stateful) ResizeInstance(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		InstanceType string `json:"instance_type"`
+	}
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	payload, err := jsonBody[Payload](r)
+	if err != nil || !instanceTypeName.MatchString(payload.InstanceType) {
+		fmt.Fprintf(os.Stderr, "invalid resize request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	resize := db.Resize{
+		InstanceId: id,
+		AccountId:  account.Id,
+		NewType:    payload.InstanceType,
+		Status:     db.REQUEST_APPROVED,
+	}
+	if account.Role != db.ROLE_TEACHER {
+		allowed, requiresApproval, err := db.CheckInstanceType(r.Context(), s.Pool, account.Id, payload.InstanceType)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			fmt.Fprintf(os.Stderr, "instance type %s not allowed for account %s\n", payload.InstanceType, account.Name)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if requiresApproval {
+			resize.Status = db.REQUEST_PENDING
+		}
+	}
+	if resize.Status == db.REQUEST_APPROVED {
+		// a queued resize is checked for the instance being stopped once it
+		// is approved
+		_, release, ok := s.beginTransition(w, r, api, id, db.INSTANCE_RESIZED)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	if resize.OldType, ok = resizableType(w, r, api, id, payload.InstanceType); !ok {
+		return
+	}
+	if resize.Status == db.REQUEST_APPROVED {
+		if err := api.ScaleInstance(r.Context(), id, payload.InstanceType); err != nil {
+			writeProviderError(w, err)
+			return
+		}
+	}
+	inserted, err := db.InsertResize(r.Context(), s.Pool, &resize)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !inserted {
+		fmt.Fprintf(os.Stderr, "resize of instance %s already pending\n", id)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	data, err := json.Marshal(resize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal resize payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if resize.Status == db.REQUEST_PENDING {
+		db.LogEvent(r.Context(), s.Pool, db.RESIZE_REQUESTED, account.Id, "instance", resizeInfo(&resize))
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		db.LogEvent(r.Context(), s.Pool, db.INSTANCE_RESIZED, account.Id, "instance", resizeInfo(&resize))
+	}
+	w.Write(data)
+}
+
+// GetPendingResizes lists the resizes awaiting the decision of the calling
+// teacher.
+func (s *Stateful) GetPendingResizes(w http.ResponseWriter, r *http.Request) {
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	if account.Role != db.ROLE_TEACHER {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	resizes, err := db.LoadPendingResizesForTeacher(r.Context(), s.Pool, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(resizes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal resizes payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// ApproveResize carries out the pending resize given by the id path value. The
+// instance has to be stopped.
+func (s *Stateful) ApproveResize(w http.ResponseWriter, r *http.Request) {
+	api, account, resize := s.authorizeResizeDecision(w, r)
+	if resize == nil {
+		return
+	}
+	state, release, ok := s.beginTransition(w, r, api, resize.InstanceId, db.INSTANCE_RESIZED)
+	if !ok {
+		return
+	}
+	defer release()
+	if _, ok := resizableType(w, r, api, resize.InstanceId, resize.NewType); !ok {
+		return
+	}
+	// decided first, so that a resize denied in the meantime is not carried out
+	decided, err := db.DecideResize(r.Context(), s.Pool, resize.Id, db.REQUEST_APPROVED, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !decided {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := api.ScaleInstance(r.Context(), resize.InstanceId, resize.NewType); err != nil {
+		// to be approved again once the instance can be scaled
+		if err := db.ReopenResize(context.WithoutCancel(r.Context()), s.Pool, resize.Id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		writeProviderError(w, err)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.INSTANCE_RESIZED, account.Id, "instance", resizeInfo(resize))
+	writeState(w, http.StatusOK, state)
+}
+
+// DenyResize rejects the pending resize given by the id path value.
+func (s *Stateful) DenyResize(w http.ResponseWriter, r *http.Request) {
+	_, account, resize := s.authorizeResizeDecision(w, r)
+	if resize == nil {
+		return
+	}
+	decided, err := db.DecideResize(r.Context(), s.Pool, resize.Id, db.REQUEST_DENIED, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !decided {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.RESIZE_DECLINED, account.Id, "instance", resizeInfo(resize))
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorizeResizeDecision returns the API access and account of the caller
+// and the pending resize given by the id path value, if the caller teaches the
+// instance to be resized. Otherwise, an error status is written.
+func (s *Stateful) authorizeResizeDecision(w http.ResponseWriter, r *http.Request) (*cloud.Access, *db.Account, *db.Resize) {
+	resizeId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, nil
+	}
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return nil, nil, nil
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, nil
+	}
+	resize, err := db.LoadPendingResize(r.Context(), s.Pool, resizeId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+	if resize == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, nil
+	}
+	teacher, err := db.IsTeacherOfInstance(r.Context(), s.Pool, resize.InstanceId, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+	if !teacher {
+		fmt.Fprintf(os.Stderr, "account %s may not decide resize %d\n", account.Name, resizeId)
+		w.WriteHeader(http.StatusForbidden)
+		return nil, nil, nil
+	}
+	if api = s.locateInstance(w, r, api, resize.InstanceId); api == nil {
+		return nil, nil, nil
+	}
+	return api, account, resize
+}
+
+// resizableType returns the current type of the instance, if it can be resized
+// to the new type, which has to be of the same family. Otherwise, an error
+// status is written. An instance to be scaled right away has to be checked for
+// being stopped before.
+func resizableType(w http.ResponseWriter, r *http.Request, api *cloud.Access, id, newType string) (string, bool) {
+	oldType, err := api.GetInstanceTypeName(r.Context(), id)
+	if err != nil {
+		writeProviderError(w, err)
+		return "", false
+	}
+	oldFamily, _, _ := strings.Cut(oldType, ".")
+	newFamily, _, _ := strings.Cut(newType, ".")
+	if oldType == newType || oldFamily != newFamily {
+		fmt.Fprintf(os.Stderr, "instance %s cannot be resized from %s to %s\n", id, oldType, newType)
+		w.WriteHeader(http.StatusBadRequest)
+		return "", false
+	}
+	return oldType, true
+}
+
+// resizeInfo describes the resize for the event log.
+func resizeInfo(resize *db.Resize) string {
+	return fmt.Sprintf("%s (%s -> %s)", resize.InstanceId, resize.OldType, resize.NewType)
+}
@@ -0,0 +1,307 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+const defaultRuleNetwork = "0.0.0.0/0"
+
+// GetIngressRules lists the rules requested for the instance given by the id
+// path value, whether pending, approved or denied.
+func (s *Stateful) GetIngressRules(w http.ResponseWriter, r *http.Request) {
+	_, _, ok := s.authorizeInstance(w, r, db.PERMISSION_VIEW)
+	if !ok {
+		return
+	}
+	rules, err := db.LoadIngressRules(r.Context(), s.Pool, r.PathValue("id"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal ingress rules payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// RequestIngressRule asks for a port of the instance given by the id path value
+// to be opened. Rules requested by teachers are applied right away; others are
+// queued for a teacher to decide and 202 is returned.
+func (s *Stateful) RequestIngressRule(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Protocol string `json:"protocol"`
+		Port     int    `json:"port"`
+		Network  string `json:"network"`
+	}
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal ingress rule request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if payload.Network == "" {
+		payload.Network = defaultRuleNetwork
+	}
+	_, network, err := net.ParseCIDR(payload.Network)
+	if err != nil || (payload.Protocol != "tcp" && payload.Protocol != "udp") || payload.Port < 1 || payload.Port > 65535 {
+		fmt.Fprintf(os.Stderr, "invalid ingress rule: %+v\n", payload)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	release, ok := s.lockInstance(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	defer release()
+	rule := db.IngressRule{
+		InstanceId: r.PathValue("id"),
+		AccountId:  account.Id,
+		Protocol:   payload.Protocol,
+		Port:       payload.Port,
+		Network:    network.String(),
+	}
+	if err := db.InsertIngressRule(r.Context(), s.Pool, &rule); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if account.Role == db.ROLE_TEACHER {
+		if err := s.applyIngressRule(r, api, account, &rule); err != nil {
+			if err := db.DeleteIngressRule(r.Context(), s.Pool, rule.Id); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			writeProviderError(w, err)
+			return
+		}
+	} else {
+		db.LogEvent(r.Context(), s.Pool, db.RULE_REQUESTED, account.Id, "rule", strconv.Itoa(rule.Id))
+	}
+	data, err := json.Marshal(rule)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal ingress rule payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rule.Status == db.REQUEST_PENDING {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	w.Write(data)
+}
+
+// RemoveIngressRule closes the port opened by the rule given by the rule path
+// value, or withdraws the rule if it has not been applied.
+func (s *Stateful) RemoveIngressRule(w http.ResponseWriter, r *http.Request) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OWNER)
+	if !ok {
+		return
+	}
+	ruleId, err := strconv.Atoi(r.PathValue("rule"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rule, err := db.LoadIngressRule(r.Context(), s.Pool, ruleId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if rule == nil || rule.InstanceId != r.PathValue("id") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	release, ok := s.lockInstance(w, r, rule.InstanceId)
+	if !ok {
+		return
+	}
+	defer release()
+	if rule.RuleId != "" {
+		if err := api.DeleteSecurityGroupRule(r.Context(), rule.SecurityGroupId, rule.RuleId); err != nil {
+			writeProviderError(w, err)
+			return
+		}
+	}
+	if err := db.DeleteIngressRule(r.Context(), s.Pool, rule.Id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.RULE_REMOVED, account.Id, "rule", strconv.Itoa(rule.Id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPendingIngressRules lists the rules awaiting the decision of the calling
+// teacher.
+func (s *Stateful) GetPendingIngressRules(w http.ResponseWriter, r *http.Request) {
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	if account.Role != db.ROLE_TEACHER {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	rules, err := db.LoadPendingIngressRulesForTeacher(r.Context(), s.Pool, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal ingress rules payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// ApproveIngressRule applies the pending rule given by the id path value to
+// the security group of its instance. Rules decided by someone else in the
+// meantime are not found.
+func (s *Stateful) ApproveIngressRule(w http.ResponseWriter, r *http.Request) {
+	api, account, rule := s.authorizeRuleDecision(w, r)
+	if rule == nil {
+		return
+	}
+	release, ok := s.lockInstance(w, r, rule.InstanceId)
+	if !ok {
+		return
+	}
+	defer release()
+	if err := s.applyIngressRule(r, api, account, rule); errors.Is(err, errRuleDecided) {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DenyIngressRule rejects the pending rule given by the id path value.
+func (s *Stateful) DenyIngressRule(w http.ResponseWriter, r *http.Request) {
+	_, account, rule := s.authorizeRuleDecision(w, r)
+	if rule == nil {
+		return
+	}
+	denied, err := db.DenyIngressRule(r.Context(), s.Pool, rule.Id, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !denied {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.RULE_DECLINED, account.Id, "rule", strconv.Itoa(rule.Id))
+	w.WriteHeader(http.StatusOK)
+}
+
+// errRuleDecided is returned for rules approved or denied by someone else in
+// the meantime.
+var errRuleDecided = errors.New("ingress rule is not pending anymore")
+
+// applyIngressRule adds the pending rule to the security group of its instance
+// and records the teacher's approval. The instance has to be locked, so that
+// its security group is created once.
+func (s *Stateful) applyIngressRule(r *http.Request, api *cloud.Access, teacher *db.Account, rule *db.IngressRule) error {
+	groupId, err := api.EnsureInstanceSecurityGroup(r.Context(), rule.InstanceId)
+	if err != nil {
+		return err
+	}
+	ruleId, err := api.AddIngressRule(r.Context(), groupId, cloud.IngressRule{
+		Protocol:    rule.Protocol,
+		Port:        int64(rule.Port),
+		Network:     rule.Network,
+		Description: fmt.Sprintf("cloud-castle rule %d", rule.Id),
+	})
+	if err != nil {
+		return err
+	}
+	approved, err := db.ApproveIngressRule(r.Context(), s.Pool, rule.Id, teacher.Id, groupId, ruleId)
+	if err != nil || !approved {
+		// the rule was decided concurrently, or its approval is unknown, so
+		// the port must not stay open without a record of it
+		if err := api.DeleteSecurityGroupRule(context.WithoutCancel(r.Context()), groupId, ruleId); err != nil {
+			fmt.Fprintf(os.Stderr, "remove unrecorded rule %s of ingress rule %d: %v\n", ruleId, rule.Id, err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if !approved {
+		return fmt.Errorf("ingress rule %d: %w", rule.Id, errRuleDecided)
+	}
+	rule.Status = db.REQUEST_APPROVED
+	db.LogEvent(r.Context(), s.Pool, db.RULE_APPLIED, teacher.Id, "rule", strconv.Itoa(rule.Id))
+	return nil
+}
+
+// authorizeRuleDecision returns the API access and account of the caller and
+// the pending rule given by the id path value, if the caller teaches the
+// rule's instance. Otherwise, an error status is written.
+func (s *Stateful) authorizeRuleDecision(w http.ResponseWriter, r *http.Request) (*cloud.Access, *db.Account, *db.IngressRule) {
+	ruleId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, nil, nil
+	}
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return nil, nil, nil
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil, nil, nil
+	}
+	rule, err := db.LoadIngressRule(r.Context(), s.Pool, ruleId)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+	if rule == nil || rule.Status != db.REQUEST_PENDING {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, nil
+	}
+	teacher, err := db.IsTeacherOfInstance(r.Context(), s.Pool, rule.InstanceId, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, nil, nil
+	}
+	if !teacher {
+		fmt.Fprintf(os.Stderr, "account %s may not decide ingress rule %d\n", account.Name, ruleId)
+		w.WriteHeader(http.StatusForbidden)
+		return nil, nil, nil
+	}
+	if api = s.locateInstance(w, r, api, rule.InstanceId); api == nil {
+		return nil, nil, nil
+	}
+	return api, account, rule
+}
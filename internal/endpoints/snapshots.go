@@ -0,0 +1,188 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// GetSnapshots lists the snapshots of the instance given by the id path
+// value, named as given when they were created.
+func (s *Stateful) GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	api, _, ok := s.authorizeInstance(w, r, db.PERMISSION_VIEW)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	snapshots, err := api.GetSnapshots(r.Context(), id)
+	if err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	names, err := db.LoadSnapshotNames(r.Context(), s.Pool, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, snapshot := range snapshots {
+		if name, ok := names[snapshot.ID]; ok {
+			snapshot.Name = name
+		}
+	}
+	payload, err := json.Marshal(snapshots)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal snapshots payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// CreateSnapshot takes a named snapshot of the instance given by the id path
+// value, unless the caller (if not a teacher) already reached the number of
+// snapshots their groups allow.
+func (s *Stateful) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Name string `json:"name"`
+	}
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OPERATE)
+	if !ok {
+		return
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil || payload.Name == "" || len(payload.Name) > 100 {
+		fmt.Fprintf(os.Stderr, "invalid snapshot request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	if account.Role != db.ROLE_TEACHER {
+		limit, err := db.LoadMaxSnapshots(r.Context(), s.Pool, account.Id)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// counted until the snapshot is recorded, so that parallel requests
+		// respect the limit
+		reservation, err := db.ReserveSnapshot(r.Context(), s.Pool, account.Id, limit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if reservation == 0 {
+			fmt.Fprintf(os.Stderr, "account %s already has %d snapshots\n", account.Name, limit)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		defer s.releaseReservation(r.Context(), reservation)
+	}
+	snapshot, err := s.takeSnapshot(r.Context(), api, account, id, payload.Name)
+	if err != nil {
+		writeActionError(w, err)
+		return
+	}
+	data, err := json.Marshal(cloud.Snapshot{ID: snapshot.SnapshotId, Name: snapshot.Name, InstanceID: id, Created: snapshot.Created})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal snapshot payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+// takeSnapshot takes a snapshot of the instance with the given name on behalf
+// of the account, records and logs it. Snapshots of instances in a state not
+// allowing them are refused with a *transitionError.
+func (s *Stateful) takeSnapshot(ctx context.Context, api *cloud.Access, account *db.Account, id, name string) (*db.Snapshot, error) {
+	_, release, err := s.lockTransition(ctx, api, id, db.INSTANCE_SNAPSHOT)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	snapshotId, err := api.CreateSnapshot(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := db.Snapshot{SnapshotId: snapshotId, InstanceId: id, AccountId: account.Id, Name: name}
+	if err := db.InsertSnapshot(ctx, s.Pool, &snapshot); err != nil {
+		return nil, err
+	}
+	db.LogEvent(ctx, s.Pool, db.INSTANCE_SNAPSHOT, account.Id, "snapshot", snapshotId)
+	return &snapshot, nil
+}
+
+// RevertSnapshot restores the instance given by the id path value to the
+// snapshot given by the snapshot path value. The instance has to be stopped.
+func (s *Stateful) RevertSnapshot(w http.ResponseWriter, r *http.Request) {
+	api, account, snapshotId, ok := s.authorizeSnapshot(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+	state, release, ok := s.beginTransition(w, r, api, id, db.SNAPSHOT_REVERTED)
+	if !ok {
+		return
+	}
+	defer release()
+	if err := api.RevertToSnapshot(r.Context(), id, snapshotId); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.SNAPSHOT_REVERTED, account.Id, "snapshot", snapshotId)
+	writeState(w, http.StatusOK, state)
+}
+
+// DeleteSnapshot removes the snapshot given by the snapshot path value from
+// the instance given by the id path value.
+func (s *Stateful) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	api, account, snapshotId, ok := s.authorizeSnapshot(w, r)
+	if !ok {
+		return
+	}
+	release, ok := s.lockInstance(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	defer release()
+	if err := api.DeleteSnapshot(r.Context(), snapshotId); err != nil {
+		writeProviderError(w, err)
+		return
+	}
+	if err := db.DeleteSnapshot(r.Context(), s.Pool, snapshotId); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	db.LogEvent(r.Context(), s.Pool, db.SNAPSHOT_DELETED, account.Id, "snapshot", snapshotId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeSnapshot checks that the caller may operate the instance given by
+// the id path value, and that the snapshot given by the snapshot path value
+// belongs to that instance.
+func (s *Stateful) authorizeSnapshot(w http.ResponseWriter, r *http.Request) (*cloud.Access, *db.Account, string, bool) {
+	api, account, ok := s.authorizeInstance(w, r, db.PERMISSION_OPERATE)
+	if !ok {
+		return nil, nil, "", false
+	}
+	snapshotId := r.PathValue("snapshot")
+	snapshot, err := api.GetSnapshot(r.Context(), snapshotId)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "get snapshot: %v\n", err)
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, "", false
+	}
+	if snapshot.InstanceID != r.PathValue("id") {
+		fmt.Fprintf(os.Stderr, "snapshot %s does not belong to instance %s\n", snapshotId, r.PathValue("id"))
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, "", false
+	}
+	return api, account, snapshotId, true
+}
@@ -0,0 +1,262 @@
+package endpoints
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/composed-ch/cloud-castle-backend/internal/sshkey"
+)
+
+// maxSSHKeyNameLength is the length of the longest key name accepted, as
+// stored in the database.
+const maxSSHKeyNameLength = 50
+
+// GetSSHKeys lists the SSH keys of the caller.
+func (s *Stateful) GetSSHKeys(w http.ResponseWriter, r *http.Request) {
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	keys, err := db.LoadSSHKeys(r.Context(), s.Pool, account.Id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload, err := json.Marshal(keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal SSH keys payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(payload)
+}
+
+// AddSSHKey adds the public key uploaded under the given name to the caller's
+// keys and registers it with the cloud. Keys of unsupported types, RSA keys
+// shorter than 2048 bits and keys the caller has already are refused.
+func (s *Stateful) AddSSHKey(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key"`
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal SSH key: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name, err := sshKeyLabel(payload.Name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	publicKey, err := sshkey.Parse(payload.PublicKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid SSH key %s: %v\n", name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := s.addSSHKey(w, r, name, publicKey)
+	if key == nil {
+		return
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal SSH key payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+// GenerateSSHKey creates an ed25519 key pair for callers who have none, adds
+// its public key under the given name like AddSSHKey, and returns the private
+// key in OpenSSH format. The private key is not kept, so it can only be
+// downloaded once.
+func (s *Stateful) GenerateSSHKey(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Name string `json:"name"`
+	}
+	type Response struct {
+		*db.SSHKey
+		PrivateKey string `json:"private_key"`
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal SSH key generation request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name, err := sshKeyLabel(payload.Name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	publicKey, privateKey, err := sshkey.Generate(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	key := s.addSSHKey(w, r, name, publicKey)
+	if key == nil {
+		return
+	}
+	data, err := json.Marshal(Response{SSHKey: key, PrivateKey: privateKey})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal SSH key payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(data)
+}
+
+// DeleteSSHKey removes the caller's SSH key given by the id path value and
+// unregisters it from the cloud, unless another account has the same key.
+// Instances created with the key keep it.
+func (s *Stateful) DeleteSSHKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	key, err := db.LoadSSHKey(r.Context(), s.Pool, account.Id, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	shared, err := db.IsSSHKeyShared(r.Context(), s.Pool, key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !shared {
+		if err := api.DeleteSSHKey(r.Context(), sshKeyName(key)); err != nil && !errors.Is(err, cloud.ErrNotFound) {
+			writeProviderError(w, err)
+			return
+		}
+	}
+	if err := db.DeleteSSHKey(r.Context(), s.Pool, key.Id); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	db.LogEvent(r.Context(), s.Pool, db.SSH_KEY_DELETED, account.Id, "fingerprint", key.Fingerprint)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addSSHKey stores the public key as the caller's key of the given name and
+// registers it with the cloud, and returns it. Otherwise, an error status is
+// written: 409 if the caller has a key of that name or the same key already.
+func (s *Stateful) addSSHKey(w http.ResponseWriter, r *http.Request, name string, publicKey *sshkey.PublicKey) *db.SSHKey {
+	api := s.getAPIAccess(w, r)
+	if api == nil {
+		return nil
+	}
+	account, err := db.LoadAccountByName(r.Context(), s.Pool, api.Username)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "load account: %v\n", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+	key := db.SSHKey{
+		AccountId:   account.Id,
+		Name:        name,
+		PublicKey:   publicKey.Authorized,
+		Type:        publicKey.Type,
+		Fingerprint: publicKey.Fingerprint,
+	}
+	inserted, err := db.InsertSSHKey(r.Context(), s.Pool, &key)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+	if !inserted {
+		fmt.Fprintf(os.Stderr, "account %s has an SSH key named %s or with fingerprint %s already\n",
+			account.Name, name, key.Fingerprint)
+		w.WriteHeader(http.StatusConflict)
+		return nil
+	}
+	if err := api.EnsureSSHKey(r.Context(), sshKeyName(&key), key.PublicKey); err != nil {
+		if err := db.DeleteSSHKey(context.WithoutCancel(r.Context()), s.Pool, key.Id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		writeProviderError(w, err)
+		return nil
+	}
+	db.LogEvent(r.Context(), s.Pool, db.SSH_KEY_ADDED, account.Id, "fingerprint", key.Fingerprint)
+	return &key
+}
+
+// ensureSSHKeys registers the keys of the account with the cloud the instance
+// is created in, if necessary, and returns the names they are registered
+// under. Keys are registered when they are added, but tenants may use
+// several clouds.
+func (s *Stateful) ensureSSHKeys(ctx context.Context, api *cloud.Access, accountId int) ([]string, error) {
+	keys, err := db.LoadSSHKeys(ctx, s.Pool, accountId)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := api.EnsureSSHKey(ctx, sshKeyName(key), key.PublicKey); err != nil {
+			return nil, err
+		}
+		names = append(names, sshKeyName(key))
+	}
+	return names, nil
+}
+
+// sshKeyName is the name the key is registered under in the cloud, which is
+// unrelated to the name the user gave. It is derived from the public key, so
+// that a key of that name registered by another deployment or account sharing
+// the cloud is the same key.
+func sshKeyName(key *db.SSHKey) string {
+	return fmt.Sprintf("cloud-castle-%x", sha256.Sum256([]byte(key.PublicKey)))
+}
+
+// sshKeyLabel returns the name given to a key without surrounding spaces, or
+// an error if it is empty, too long or contains control characters.
+func sshKeyLabel(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxSSHKeyNameLength {
+		return "", fmt.Errorf("SSH key name must have 1 to %d characters", maxSSHKeyNameLength)
+	}
+	if strings.ContainsFunc(name, unicode.IsControl) {
+		return "", fmt.Errorf("SSH key name %q contains control characters", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,341 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+const (
+	// heartbeatInterval is how often a comment is sent to keep idle streams
+	// open through proxies. The instances visible to the subscriber are
+	// reloaded at the same time if assignments changed.
+	heartbeatInterval = 15 * time.Second
+	// visibleReloadInterval is how often the instances visible to a
+	// subscriber are reloaded anyway, for assignments changed by the
+	// command-line tools or other replicas of the backend.
+	visibleReloadInterval = 5 * time.Minute
+	// pollerGracePeriod is how long a poller keeps running after its last
+	// subscriber left, so that reconnecting subscribers can resume.
+	pollerGracePeriod = 2 * time.Minute
+	// recentChanges is how many state changes are kept per tenant for
+	// subscribers resuming with Last-Event-ID.
+	recentChanges = 256
+	// subscriberBuffer is how many state changes may queue up for a slow
+	// subscriber before further changes are dropped.
+	subscriberBuffer = 64
+	// stateGone is reported for instances that disappeared from the listing.
+	stateGone = "destroyed"
+)
+
+// StateChange is a transition of an instance from one state to another.
+type StateChange struct {
+	Id         int64     `json:"id"`
+	InstanceId string    `json:"instance_id"`
+	Zone       string    `json:"zone"`
+	State      string    `json:"state"`
+	Previous   string    `json:"previous"`
+	Time       time.Time `json:"time"`
+}
+
+// StreamInstanceStates sends the state changes of the caller's instances (and
+// for teachers, of their groups' instances) as server-sent events. Clients
+// reconnecting with a Last-Event-ID header receive the changes they missed, as
+// far as they are still kept.
+func (s *Stateful) StreamInstanceStates(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "response writer does not support flushing")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	account := s.getAccount(w, r)
+	if account == nil {
+		return
+	}
+	visible, err := s.visibleInstances(r.Context(), account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var lastId int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		if lastId, err = strconv.ParseInt(header, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	changes, missed, unsubscribe := s.states.subscribe(s, account.Tenant, lastId)
+	defer unsubscribe()
+	assignments, reloaded := s.states.assignments.Load(), time.Now()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	for _, change := range missed {
+		if visible[change.InstanceId] {
+			writeStateChange(w, change)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			// the server shuts down, the client reconnects to another replica
+			return
+		case change := <-changes:
+			if visible[change.InstanceId] {
+				writeStateChange(w, change)
+				flusher.Flush()
+			}
+		case <-heartbeat.C:
+			current := s.states.assignments.Load()
+			if current != assignments || time.Since(reloaded) >= visibleReloadInterval {
+				if reloadedVisible, err := s.visibleInstances(r.Context(), account); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				} else {
+					visible, assignments, reloaded = reloadedVisible, current, time.Now()
+				}
+			}
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeStateChange(w http.ResponseWriter, change *StateChange) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal state change: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: state\ndata: %s\n\n", change.Id, data)
+}
+
+// visibleInstances returns the IDs of the instances assigned to the account
+// and, for teachers, of the instances of their groups.
+func (s *Stateful) visibleInstances(ctx context.Context, account *db.Account) (map[string]bool, error) {
+	assigned, err := db.LoadAssignedInstances(ctx, s.Pool, account.Id)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool, len(assigned))
+	for instanceId := range assigned {
+		visible[instanceId] = true
+	}
+	if account.Role == db.ROLE_TEACHER {
+		taught, err := db.LoadTaughtInstances(ctx, s.Pool, account.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, instanceId := range taught {
+			visible[instanceId] = true
+		}
+	}
+	return visible, nil
+}
+
+// stateHub runs one poller per tenant for as long as the tenant has
+// subscribers, so that the number of Exoscale API calls does not grow with
+// the number of subscribers.
+type stateHub struct {
+	interval time.Duration
+	mu       sync.Mutex
+	pollers  map[string]*statePoller
+	// assignments is increased whenever instances are assigned, so that
+	// subscribers reload the instances visible to them.
+	assignments atomic.Int64
+}
+
+// assignmentsChanged makes subscribers reload the instances visible to them.
+func (h *stateHub) assignmentsChanged() {
+	h.assignments.Add(1)
+}
+
+func newStateHub(interval time.Duration) *stateHub {
+	return &stateHub{interval: interval, pollers: make(map[string]*statePoller)}
+}
+
+// subscribe returns a channel of the tenant's state changes, the kept changes
+// after lastId, and a function to end the subscription.
+func (h *stateHub) subscribe(s *Stateful, tenant string, lastId int64) (<-chan *StateChange, []*StateChange, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	poller, ok := h.pollers[tenant]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		poller = &statePoller{
+			tenant:      tenant,
+			cancel:      cancel,
+			subscribers: make(map[chan *StateChange]struct{}),
+			states:      make(map[string]*StateChange),
+			// continue after the IDs of previous runs, so that stale
+			// Last-Event-IDs do not skip any changes
+			nextId: time.Now().UnixMilli(),
+		}
+		h.pollers[tenant] = poller
+		go poller.run(ctx, s, h.interval)
+	} else if poller.idle != nil {
+		poller.idle.Stop()
+		poller.idle = nil
+	}
+	changes := make(chan *StateChange, subscriberBuffer)
+	missed := poller.add(changes, lastId)
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if poller.remove(changes) > 0 {
+			return
+		}
+		var idle *time.Timer
+		idle = time.AfterFunc(pollerGracePeriod, func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			// a subscriber may have come back in the meantime
+			if poller.idle == idle {
+				poller.cancel()
+				delete(h.pollers, tenant)
+			}
+		})
+		poller.idle = idle
+	}
+	return changes, missed, unsubscribe
+}
+
+// statePoller lists the instances of a tenant in all its zones and publishes
+// the changes of their states to its subscribers. Without subscribers, it
+// keeps polling for the grace period, so that the changes in between are kept
+// for subscribers resuming.
+type statePoller struct {
+	tenant      string
+	cancel      context.CancelFunc
+	idle        *time.Timer // guarded by the hub's lock
+	mu          sync.Mutex
+	subscribers map[chan *StateChange]struct{}
+	states      map[string]*StateChange
+	recent      []*StateChange
+	nextId      int64
+	initialized bool
+}
+
+func (p *statePoller) add(changes chan *StateChange, lastId int64) []*StateChange {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.subscribers[changes] = struct{}{}
+	missed := make([]*StateChange, 0)
+	if lastId == 0 {
+		return missed
+	}
+	for _, change := range p.recent {
+		if change.Id > lastId {
+			missed = append(missed, change)
+		}
+	}
+	return missed
+}
+
+// remove returns the number of remaining subscribers.
+func (p *statePoller) remove(changes chan *StateChange) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.subscribers, changes)
+	return len(p.subscribers)
+}
+
+func (p *statePoller) run(ctx context.Context, s *Stateful, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.poll(ctx, s)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll compares the current states with the previous ones. The first poll only
+// records the states. Instances of zones that cannot be listed are kept as
+// they were.
+func (p *statePoller) poll(ctx context.Context, s *Stateful) {
+	accesses, err := s.GetAPIAccessesForTenant(p.tenant)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	now := time.Now()
+	current := make(map[string]*StateChange)
+	listed := make(map[string]bool)
+	for _, api := range accesses {
+		instances, err := api.GetInstances(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "poll instance states of tenant %s in zone %s: %v\n", p.tenant, api.Zone, err)
+			continue
+		}
+		listed[api.Zone] = true
+		for _, instance := range instances {
+			current[instance.ID] = &StateChange{InstanceId: instance.ID, Zone: api.Zone, State: instance.State, Time: now}
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, change := range current {
+		previous, ok := p.states[id]
+		if !ok || previous.State != change.State {
+			if ok {
+				change.Previous = previous.State
+			}
+			p.states[id] = change
+			if p.initialized {
+				p.publish(change)
+			}
+		}
+	}
+	for id, previous := range p.states {
+		if _, ok := current[id]; !ok && listed[previous.Zone] {
+			delete(p.states, id)
+			p.publish(&StateChange{
+				InstanceId: id,
+				Zone:       previous.Zone,
+				State:      stateGone,
+				Previous:   previous.State,
+				Time:       now,
+			})
+		}
+	}
+	p.initialized = true
+}
+
+// publish must be called with the lock held.
+func (p *statePoller) publish(change *StateChange) {
+	p.nextId++
+	change.Id = p.nextId
+	p.recent = append(p.recent, change)
+	if len(p.recent) > recentChanges {
+		p.recent = p.recent[len(p.recent)-recentChanges:]
+	}
+	for subscriber := range p.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+			fmt.Fprintf(os.Stderr, "dropping state change %d for slow subscriber\n", change.Id)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+)
+
+// transition is the part of the state machine of instances covering an
+// action: the states the action may be carried out in, and the state it
+// leaves the instance in, which is the state it was in if empty.
+type transition struct {
+	from []string
+	to   string
+}
+
+// transitions maps the kinds of actions to their transitions, using the states
+// the providers report. A rebooting instance is starting until it runs again.
+// Force stopping is meant for instances hanging in a transitional state.
+var transitions = map[db.Kind]transition{
+	db.INSTANCE_START:      {from: []string{"stopped"}, to: "starting"},
+	db.INSTANCE_STOP:       {from: []string{"running"}, to: "stopping"},
+	db.INSTANCE_REBOOT:     {from: []string{"running"}, to: "starting"},
+	db.INSTANCE_FORCE_STOP: {from: []string{"running", "starting", "stopping", "error"}, to: "stopped"},
+	db.INSTANCE_RESET:      {from: []string{"running", "stopped"}},
+	db.INSTANCE_RESIZED:    {from: []string{"stopped"}},
+	db.INSTANCE_SNAPSHOT:   {from: []string{"running", "stopped"}},
+	db.INSTANCE_PASSWORD:   {from: []string{"running", "stopped"}},
+	db.INSTANCE_DELETING:   {from: []string{"running", "stopped", "error"}},
+	db.SNAPSHOT_REVERTED:   {from: []string{"stopped"}},
+}
+
+// stateResponse tells the state an instance is in after an action, or was in
+// when the action was refused.
+type stateResponse struct {
+	InstanceState string `json:"instance_state"`
+}
+
+// transitionError refuses an action because another action on the instance
+// is in progress, or because the instance is in a state the action may not be
+// carried out in.
+type transitionError struct {
+	id    string
+	kind  db.Kind
+	state string
+}
+
+func (e *transitionError) Error() string {
+	if e.state == "" {
+		return fmt.Sprintf("instance %s is locked by another action, refusing %s", e.id, e.kind)
+	}
+	return fmt.Sprintf("instance %s is %s, refusing %s", e.id, e.state, e.kind)
+}
+
+// beginTransition takes the lock of the instance and checks that the action of
+// the given kind may be carried out in the instance's current state. It
+// returns the state the action leaves the instance in and the function
+// releasing the lock, which has to be called once the action was requested.
+// If another action on the instance is in progress or the action is invalid in
+// the current state, 409 is written, otherwise another error status.
+func (s *Stateful) beginTransition(w http.ResponseWriter, r *http.Request, api *cloud.Access, id string, kind db.Kind) (string, func(), bool) {
+	state, release, err := s.lockTransition(r.Context(), api, id, kind)
+	if err != nil {
+		writeActionError(w, err)
+		return "", nil, false
+	}
+	return state, release, true
+}
+
+// errPendingDeletion refuses the actions booting an instance pending deletion.
+var errPendingDeletion = errors.New("instance is pending deletion")
+
+// writeActionError writes the status for an action that failed with the error,
+// along with the state of the instance if the action is invalid in it.
+func writeActionError(w http.ResponseWriter, err error) {
+	fmt.Fprintln(os.Stderr, err)
+	var refused *transitionError
+	if errors.As(err, &refused) && refused.state != "" {
+		writeState(w, http.StatusConflict, refused.state)
+		return
+	}
+	w.WriteHeader(actionErrorStatus(err))
+}
+
+// actionErrorStatus returns the status written by writeActionError.
+func actionErrorStatus(err error) int {
+	var refused *transitionError
+	if errors.As(err, &refused) || errors.Is(err, errPendingDeletion) {
+		return http.StatusConflict
+	}
+	return providerErrorStatus(err)
+}
+
+// lockTransition is beginTransition for callers not writing a response of
+// their own. It returns a *transitionError if the action is refused.
+func (s *Stateful) lockTransition(ctx context.Context, api *cloud.Access, id string, kind db.Kind) (string, func(), error) {
+	release, err := db.TryLockInstance(ctx, s.Pool, id)
+	if err != nil {
+		return "", nil, err
+	}
+	if release == nil {
+		return "", nil, &transitionError{id: id, kind: kind}
+	}
+	state, err := s.currentState(ctx, api, id)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	t := transitions[kind]
+	if !slices.Contains(t.from, state) {
+		release()
+		return "", nil, &transitionError{id: id, kind: kind, state: state}
+	}
+	if t.to == "" {
+		return state, release, nil
+	}
+	return t.to, release, nil
+}
+
+// lockInstance takes the lock of the instance for an action that may be
+// carried out in any state, and returns the function releasing it. If another
+// action on the instance is in progress, 409 is written, otherwise another
+// error status.
+func (s *Stateful) lockInstance(w http.ResponseWriter, r *http.Request, id string) (func(), bool) {
+	release, err := db.TryLockInstance(r.Context(), s.Pool, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if release == nil {
+		fmt.Fprintf(os.Stderr, "instance %s is locked by another action\n", id)
+		w.WriteHeader(http.StatusConflict)
+		return nil, false
+	}
+	return release, true
+}
+
+// currentState returns the transitional state of the operation pending on the
+// instance, if any, since the provider may not report it yet, and otherwise
+// the state reported by the provider.
+func (s *Stateful) currentState(ctx context.Context, api *cloud.Access, id string) (string, error) {
+	operation, err := db.LoadPendingOperation(ctx, s.Pool, id)
+	if err != nil {
+		return "", err
+	}
+	if operation != nil && transitions[operation.Kind].to != "" {
+		return transitions[operation.Kind].to, nil
+	}
+	instance, err := api.GetInstance(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return instance.State, nil
+}
+
+func writeState(w http.ResponseWriter, status int, state string) {
+	payload, err := json.Marshal(stateResponse{InstanceState: state})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal state payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(payload)
+}
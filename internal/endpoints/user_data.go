@@ -0,0 +1,107 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/composed-ch/cloud-castle-backend/internal/db"
+	"github.com/composed-ch/cloud-castle-backend/internal/userdata"
+)
+
+// PreviewUserData renders a user-data template for the group given by the id
+// path value, as it would be rendered for an instance of the member given by
+// username, or for sample data if no username is given. Only teachers of the
+// group may do so. Templates that cannot be rendered are refused with 422 and
+// the reason.
+func (s *Stateful) PreviewUserData(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		UserData string `json:"user_data"`
+		Username string `json:"username"`
+	}
+	type Response struct {
+		UserData string `json:"user_data,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}
+	groupId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.getGroupTeacher(w, r, groupId) == nil {
+		return
+	}
+	payload, err := jsonBody[Payload](r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unmarshal user-data preview request: %v\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	status, response := http.StatusOK, Response{}
+	if payload.Username == "" {
+		response.UserData, err = userdata.Render(payload.UserData, userdata.Sample)
+	} else {
+		account, loadErr := db.LoadAccountByName(r.Context(), s.Pool, payload.Username)
+		if loadErr != nil {
+			fmt.Fprintf(os.Stderr, "load account: %v\n", loadErr)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		member, loadErr := db.IsGroupMember(r.Context(), s.Pool, groupId, account.Id)
+		if loadErr != nil {
+			fmt.Fprintln(os.Stderr, loadErr)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			fmt.Fprintf(os.Stderr, "account %s is not a member of group %d\n", account.Name, groupId)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		offering := db.Offering{GroupId: groupId, UserData: payload.UserData}
+		response.UserData, err = s.renderUserData(r.Context(), &offering, account, userdata.Sample.Hostname)
+	}
+	if err != nil {
+		status, response.Error = http.StatusUnprocessableEntity, err.Error()
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "marshal user-data preview payload: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// renderUserData renders the user-data template of the offering for the
+// account and the instance of the given name.
+func (s *Stateful) renderUserData(ctx context.Context, offering *db.Offering, account *db.Account, hostname string) (string, error) {
+	if offering.UserData == "" {
+		return "", nil
+	}
+	group, err := db.LoadGroupById(ctx, s.Pool, offering.GroupId)
+	if err != nil {
+		return "", err
+	}
+	keys, err := db.LoadSSHKeys(ctx, s.Pool, account.Id)
+	if err != nil {
+		return "", err
+	}
+	data := userdata.Data{
+		Username: account.Name,
+		Email:    account.Email,
+		Role:     account.Role,
+		Group:    group.Name,
+		Course:   account.Tenant,
+		Hostname: hostname,
+		SSHKeys:  make([]string, 0, len(keys)),
+	}
+	for _, key := range keys {
+		data.SSHKeys = append(data.SSHKeys, key.PublicKey)
+	}
+	return userdata.Render(offering.UserData, data)
+}
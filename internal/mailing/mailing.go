@@ -22,6 +22,18 @@ func CreatePasswordResetEmail(accountId int, email, token string) string {
 		<p>Liebe Grüsse vom Cloud Castle!</p>`, username, resetURL)
 }
 
+func CreateLoginLinkEmail(linkId int, email, token string) string {
+	atIndex := strings.Index(email, "@")
+	username := email[:atIndex]
+	loginURL := fmt.Sprintf("https://app.cloud-castle.ch/login-link/%d/%s", linkId, token)
+	return fmt.Sprintf(
+		`<p>Hallo %s!</p>
+		<p>Du hast einen Anmeldelink für <a href="https://app.cloud-castle.ch">Cloud Castle</a> angefragt.</p>
+		<p>Wenn du das nicht warst, kannst du diese Nachricht löschen.</p>
+		<p>Wenn du das warst, kannst du dich <a href="%s">mit diesem Link anmelden</a>. Der Link ist 15 Minuten lang gültig und kann nur einmal verwendet werden.</p>
+		<p>Liebe Grüsse vom Cloud Castle!</p>`, username, loginURL)
+}
+
 type Email struct {
 	From          string
 	To            string
@@ -0,0 +1,91 @@
+// Package sshkey validates the SSH public keys users upload and generates key
+// pairs for users who do not have one.
+package sshkey
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// minRSABits is the size of the smallest RSA key accepted.
+const minRSABits = 2048
+
+// allowedTypes are the key types accepted, which are those Exoscale and
+// OpenStack support except for DSA.
+var allowedTypes = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSA,
+}
+
+// PublicKey is a validated SSH public key.
+type PublicKey struct {
+	// Type is the key's algorithm, e.g. "ssh-ed25519".
+	Type string
+	// Fingerprint is the SHA256 fingerprint as shown by ssh-keygen -l.
+	Fingerprint string
+	// Authorized is the key in the format of authorized_keys, without the
+	// comment.
+	Authorized string
+}
+
+// Parse validates the public key given in the format of authorized_keys, i.e.
+// as found in an id_*.pub file. Options and certificates are refused.
+func Parse(authorized string) (*PublicKey, error) {
+	authorized = strings.TrimSpace(authorized)
+	if strings.ContainsAny(authorized, "\r\n") {
+		return nil, errors.New("more than one line given")
+	}
+	key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(authorized))
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %v", err)
+	}
+	if len(options) > 0 {
+		return nil, errors.New("public key with options")
+	}
+	if !slices.Contains(allowedTypes, key.Type()) {
+		return nil, fmt.Errorf("key type %s not allowed", key.Type())
+	}
+	if crypto, ok := key.(ssh.CryptoPublicKey); ok {
+		if rsaKey, ok := crypto.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key of %d bits, at least %d required", rsaKey.N.BitLen(), minRSABits)
+		}
+	}
+	return &PublicKey{
+		Type:        key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Authorized:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}, nil
+}
+
+// Generate creates an ed25519 key pair and returns the public key and the
+// private key in OpenSSH format, which carries the comment.
+func Generate(comment string) (*PublicKey, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("generate ed25519 key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, comment)
+	if err != nil {
+		return nil, "", fmt.Errorf("marshal private key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, "", fmt.Errorf("convert public key: %v", err)
+	}
+	return &PublicKey{
+		Type:        key.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Authorized:  strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+	}, string(pem.EncodeToMemory(block)), nil
+}
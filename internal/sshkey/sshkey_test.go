@@ -0,0 +1,58 @@
+package sshkey
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParse(t *testing.T) {
+	generated, _, err := Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := Parse("  " + generated.Authorized + " alice@laptop\n")
+	if err != nil {
+		t.Fatalf("parse generated key: %v", err)
+	}
+	if key.Type != "ssh-ed25519" || key.Fingerprint != generated.Fingerprint || strings.Contains(key.Authorized, "alice") {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallKey, err := ssh.NewPublicKey(&small.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, authorized := range map[string]string{
+		"empty":     "",
+		"garbage":   "ssh-ed25519 AAAAnotbase64!",
+		"two keys":  generated.Authorized + "\n" + generated.Authorized,
+		"options":   `command="ls" ` + generated.Authorized,
+		"small RSA": string(ssh.MarshalAuthorizedKey(smallKey)),
+	} {
+		if _, err := Parse(authorized); err == nil {
+			t.Errorf("expected %s to be refused", name)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	public, private, err := Generate("alice@cloud-castle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.ParsePrivateKey([]byte(private))
+	if err != nil {
+		t.Fatalf("parse private key: %v", err)
+	}
+	if ssh.FingerprintSHA256(signer.PublicKey()) != public.Fingerprint {
+		t.Errorf("private key does not match public key %s", public.Fingerprint)
+	}
+}
@@ -0,0 +1,97 @@
+// Package userdata renders the cloud-init user-data of offerings, which are Go
+// templates (see text/template) filled in for the student an instance is
+// created for.
+package userdata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// maxEncodedSize is the size of the largest user-data accepted once encoded in
+// base64, which is the limit of Exoscale.
+const maxEncodedSize = 32768
+
+// Data holds the variables available to templates, e.g. {{.Username}}.
+type Data struct {
+	Username string
+	Email    string
+	// Role is either "student" or "teacher".
+	Role string
+	// Group is the name of the group the offering belongs to.
+	Group string
+	// Course is the tenant, which stands for a course such as "m346".
+	Course string
+	// Hostname is the name of the instance.
+	Hostname string
+	// SSHKeys are the public keys of the account in authorized_keys format.
+	SSHKeys []string
+}
+
+// Sample is the data templates are rendered with to validate them and to
+// preview them without a student.
+var Sample = Data{
+	Username: "joe.doe",
+	Email:    "joe.doe@example.com",
+	Role:     "student",
+	Group:    "team-a",
+	Course:   "m346",
+	Hostname: "joe-doe-debian-x7k2",
+	SSHKeys:  []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIBYW0Dy8fDGkf1jL8h2Dm0l8CFeRE2MK8bmDg9AtKQZq"},
+}
+
+var funcs = template.FuncMap{
+	// quote returns the string as double-quoted YAML scalar, to be safe for
+	// values such as "yes" or containing a colon.
+	"quote": func(s string) (string, error) {
+		quoted, err := json.Marshal(s)
+		return string(quoted), err
+	},
+}
+
+// Validate checks that the template renders into valid user-data for the
+// sample data, see Render.
+func Validate(text string) error {
+	_, err := Render(text, Sample)
+	return err
+}
+
+// Render fills in the template and checks the result: shell scripts (starting
+// with "#!") are passed on as they are, anything else has to be cloud-config
+// starting with "#cloud-config" and holding a YAML mapping. An empty template
+// renders into empty user-data.
+func Render(text string, data Data) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("user-data").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parse user-data template: %v", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("render user-data template: %v", err)
+	}
+	rendered := out.String()
+	if base64.StdEncoding.EncodedLen(len(rendered)) > maxEncodedSize {
+		return "", fmt.Errorf("user-data of %d bytes exceeds %d bytes encoded in base64", len(rendered), maxEncodedSize)
+	}
+	if strings.HasPrefix(rendered, "#!") {
+		return rendered, nil
+	}
+	if !strings.HasPrefix(rendered, "#cloud-config") {
+		return "", errors.New("user-data must start with #cloud-config or #!")
+	}
+	var config map[string]any
+	if err := yaml.Unmarshal([]byte(rendered), &config); err != nil {
+		return "", fmt.Errorf("rendered user-data is no valid YAML mapping: %v", err)
+	}
+	return rendered, nil
+}
@@ -0,0 +1,54 @@
+package userdata
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	text := `#cloud-config
+hostname: {{.Hostname}}
+users:
+  - name: {{.Username}}
+    gecos: {{quote .Email}}
+    ssh_authorized_keys:
+{{- range .SSHKeys}}
+      - {{.}}
+{{- end}}
+packages: [git, {{if eq .Course "m346"}}docker.io{{else}}podman{{end}}]
+`
+	data := Sample
+	data.SSHKeys = append(data.SSHKeys, "ssh-ed25519 AAAAsecond")
+	rendered, err := Render(text, data)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	for _, expected := range []string{"hostname: joe-doe-debian-x7k2", "name: joe.doe", `gecos: "joe.doe@example.com"`,
+		"      - ssh-ed25519 AAAAsecond", "docker.io"} {
+		if !strings.Contains(rendered, expected) {
+			t.Errorf("expected %q in rendered user-data:\n%s", expected, rendered)
+		}
+	}
+
+	if rendered, err := Render("#!/bin/sh\necho {{.Username}} > /etc/motd\n", Sample); err != nil || !strings.Contains(rendered, "joe.doe") {
+		t.Errorf("expected script to be rendered, got %q (%v)", rendered, err)
+	}
+	if rendered, err := Render("", Sample); err != nil || rendered != "" {
+		t.Errorf("expected empty user-data, got %q (%v)", rendered, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, text := range map[string]string{
+		"syntax":        "#cloud-config\nhostname: {{.Hostname}\n",
+		"unknown field": "#cloud-config\nhostname: {{.Instance}}\n",
+		"header":        "hostname: {{.Hostname}}\n",
+		"YAML":          "#cloud-config\npackages: [git\n",
+		"no mapping":    "#cloud-config\n- git\n",
+		"size":          "#cloud-config\nwrite_files: [{content: " + strings.Repeat("x", 30000) + "}]\n",
+	} {
+		if err := Validate(text); err == nil {
+			t.Errorf("expected %s error", name)
+		}
+	}
+}
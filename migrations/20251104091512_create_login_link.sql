@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists login_link (
+    id integer primary key generated always as identity,
+    account_id integer not null references account (id)
+        on delete cascade,
+    token varchar(255) not null,
+    created timestamptz not null default now(),
+    expires timestamptz not null default now() + interval '15 minutes'
+);
+create table if not exists tenant_setting (
+    tenant varchar(100) primary key,
+    login_link_enabled boolean not null default false
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists tenant_setting;
+drop table if exists login_link;
+-- +goose StatementEnd
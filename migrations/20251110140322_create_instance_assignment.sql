@@ -0,0 +1,39 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists account_group (
+    id integer primary key generated always as identity,
+    name varchar(100) not null,
+    tenant varchar(100) not null,
+    constraint unique_group_name unique (name, tenant)
+);
+create table if not exists group_member (
+    group_id integer not null references account_group (id)
+        on delete cascade,
+    account_id integer not null references account (id)
+        on delete cascade,
+    primary key (group_id, account_id)
+);
+create table if not exists instance_assignment (
+    id integer primary key generated always as identity,
+    instance_id varchar(36) not null,
+    zone varchar(100) not null,
+    tenant varchar(100) not null,
+    account_id integer null references account (id)
+        on delete cascade,
+    group_id integer null references account_group (id)
+        on delete cascade,
+    permission varchar(20) not null,
+    created timestamptz not null default now(),
+    constraint account_or_group check ((account_id is null) <> (group_id is null)),
+    constraint valid_permission check (permission in ('view', 'operate', 'owner')),
+    constraint unique_account_assignment unique (instance_id, account_id),
+    constraint unique_group_assignment unique (instance_id, group_id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists instance_assignment;
+drop table if exists group_member;
+drop table if exists account_group;
+-- +goose StatementEnd
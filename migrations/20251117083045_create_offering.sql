@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists offering (
+    id integer primary key generated always as identity,
+    group_id integer not null references account_group (id)
+        on delete cascade,
+    name varchar(100) not null,
+    template_id varchar(36) not null,
+    instance_type varchar(100) not null,
+    disk_size integer not null default 10,
+    security_groups text[] not null default '{}',
+    user_data text null,
+    created timestamptz not null default now(),
+    constraint unique_offering_name unique (group_id, name)
+);
+alter table account_group add column max_instances integer not null default 1;
+alter table instance_assignment add column offering_id integer null references offering (id)
+    on delete set null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table instance_assignment drop column offering_id;
+alter table account_group drop column max_instances;
+drop table if exists offering;
+-- +goose StatementEnd
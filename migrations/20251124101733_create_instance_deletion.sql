@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists instance_deletion (
+    id integer primary key generated always as identity,
+    instance_id varchar(36) not null unique,
+    zone varchar(100) not null,
+    tenant varchar(100) not null,
+    account_id integer not null references account (id)
+        on delete cascade,
+    requested timestamptz not null default now(),
+    due timestamptz not null,
+    snapshot boolean not null default false,
+    attempts integer not null default 0,
+    last_error text null
+);
+alter table offering add column allow_delete boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table offering drop column allow_delete;
+drop table if exists instance_deletion;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists instance_snapshot (
+    id integer primary key generated always as identity,
+    snapshot_id varchar(36) not null unique,
+    instance_id varchar(36) not null,
+    account_id integer not null references account (id)
+        on delete cascade,
+    name varchar(100) not null,
+    created timestamptz not null default now()
+);
+alter table account_group add column max_snapshots integer not null default 3;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table account_group drop column max_snapshots;
+drop table if exists instance_snapshot;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists instance_reset (
+    id integer primary key generated always as identity,
+    instance_id varchar(36) not null,
+    account_id integer not null references account (id)
+        on delete cascade,
+    template_id varchar(36) not null,
+    happened timestamptz not null default now()
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists instance_reset;
+-- +goose StatementEnd
@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists instance_password (
+    instance_id varchar(36) primary key,
+    reset_by integer not null references account (id)
+        on delete cascade,
+    reset timestamptz not null default now(),
+    revealed_by integer null references account (id)
+        on delete set null,
+    revealed timestamptz null
+);
+alter table tenant_setting add column exam_mode boolean not null default false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table tenant_setting drop column exam_mode;
+drop table if exists instance_password;
+-- +goose StatementEnd
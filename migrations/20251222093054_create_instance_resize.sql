@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists group_instance_type (
+    group_id integer not null references account_group (id)
+        on delete cascade,
+    instance_type varchar(100) not null,
+    requires_approval boolean not null default false,
+    primary key (group_id, instance_type)
+);
+create table if not exists instance_resize (
+    id integer primary key generated always as identity,
+    instance_id varchar(36) not null,
+    account_id integer not null references account (id)
+        on delete cascade,
+    old_type varchar(100) not null,
+    new_type varchar(100) not null,
+    requested timestamptz not null default now(),
+    status varchar(20) not null default 'pending',
+    decided_by integer null references account (id)
+        on delete set null,
+    decided timestamptz null,
+    constraint valid_status check (status in ('pending', 'approved', 'denied'))
+);
+create unique index if not exists instance_resize_pending
+    on instance_resize (instance_id) where status = 'pending';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists instance_resize;
+drop table if exists group_instance_type;
+-- +goose StatementEnd
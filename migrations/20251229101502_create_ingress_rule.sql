@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists ingress_rule (
+    id integer primary key generated always as identity,
+    instance_id varchar(36) not null,
+    account_id integer not null references account (id)
+        on delete cascade,
+    protocol varchar(3) not null,
+    port integer not null,
+    network varchar(43) not null,
+    requested timestamptz not null default now(),
+    status varchar(20) not null default 'pending',
+    decided_by integer null references account (id)
+        on delete set null,
+    decided timestamptz null,
+    security_group_id varchar(36) null,
+    rule_id varchar(36) null,
+    constraint valid_protocol check (protocol in ('tcp', 'udp')),
+    constraint valid_port check (port between 1 and 65535),
+    constraint valid_status check (status in ('pending', 'approved', 'denied'))
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists ingress_rule;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists operation (
+    id integer primary key generated always as identity,
+    account_id integer not null references account (id)
+        on delete cascade,
+    instance_id varchar(36) not null,
+    zone varchar(100) not null,
+    tenant varchar(100) not null,
+    kind varchar(50) not null,
+    exoscale_id varchar(36) not null,
+    state varchar(20) not null default 'pending',
+    error text null,
+    created timestamptz not null default now(),
+    finished timestamptz null,
+    constraint valid_state check (state in ('pending', 'success', 'failure'))
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists operation;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table api_key add column provider varchar(20) not null default 'exoscale';
+alter table api_key add column endpoint varchar(255) null;
+alter table api_key add constraint valid_provider check (provider in ('exoscale', 'openstack'));
+alter table api_key add constraint endpoint_for_openstack check (provider <> 'openstack' or endpoint is not null);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table api_key drop constraint endpoint_for_openstack;
+alter table api_key drop constraint valid_provider;
+alter table api_key drop column endpoint;
+alter table api_key drop column provider;
+-- +goose StatementEnd
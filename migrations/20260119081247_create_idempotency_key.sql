@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists idempotency_key (
+    id integer primary key generated always as identity,
+    account_id integer not null references account (id)
+        on delete cascade,
+    key varchar(255) not null,
+    fingerprint char(64) not null,
+    status integer null,
+    location varchar(255) null,
+    body bytea null,
+    created timestamptz not null default now(),
+    constraint unique_key_per_account unique (account_id, key)
+);
+create index if not exists idempotency_key_created on idempotency_key (created);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists idempotency_key;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists ssh_key (
+    id integer primary key generated always as identity,
+    account_id integer not null references account (id)
+        on delete cascade,
+    name varchar(50) not null,
+    public_key text not null,
+    key_type varchar(30) not null,
+    fingerprint varchar(60) not null,
+    created timestamptz not null default now(),
+    constraint unique_name_per_account unique (account_id, name),
+    constraint unique_fingerprint_per_account unique (account_id, fingerprint)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists ssh_key;
+-- +goose StatementEnd
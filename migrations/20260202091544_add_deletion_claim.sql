@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table instance_deletion add column claimed timestamptz null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table instance_deletion drop column claimed;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table operation alter column exoscale_id type varchar(100);
+alter table operation rename column exoscale_id to provider_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table operation rename column provider_id to exoscale_id;
+alter table operation alter column exoscale_id type varchar(36);
+-- +goose StatementEnd
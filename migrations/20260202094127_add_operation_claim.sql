@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table operation add column claimed timestamptz null;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table operation drop column claimed;
+-- +goose StatementEnd
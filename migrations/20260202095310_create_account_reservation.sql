@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+create table if not exists account_reservation (
+    id integer primary key generated always as identity,
+    account_id integer not null references account (id)
+        on delete cascade,
+    kind varchar(20) not null,
+    created timestamptz not null default now(),
+    constraint valid_kind check (kind in ('instance', 'snapshot'))
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table if exists account_reservation;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table instance_deletion drop column snapshot;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table instance_deletion add column snapshot boolean not null default false;
+-- +goose StatementEnd
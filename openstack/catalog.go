@@ -0,0 +1,113 @@
+package openstack
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// ListZones returns the regions offering compute.
+func (c *Client) ListZones(ctx context.Context) ([]string, error) {
+	_, catalog, err := c.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]string, 0)
+	for _, service := range catalog {
+		if service.Type != "compute" {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface == "public" && !slices.Contains(zones, endpoint.Region) {
+				zones = append(zones, endpoint.Region)
+			}
+		}
+	}
+	slices.Sort(zones)
+	return zones, nil
+}
+
+type flavor struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	VCPUs int64  `json:"vcpus"`
+	// RAM is given in MiB.
+	RAM int64 `json:"ram"`
+}
+
+func (c *Client) listFlavors(ctx context.Context) ([]flavor, error) {
+	var resp struct {
+		Flavors []flavor `json:"flavors"`
+	}
+	if _, err := c.request(ctx, http.MethodGet, "compute", "/flavors/detail", nil, &resp); err != nil {
+		return nil, errorf("list flavors: %w", err)
+	}
+	return resp.Flavors, nil
+}
+
+func (c *Client) findFlavor(ctx context.Context, name string) (*flavor, error) {
+	flavors, err := c.listFlavors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, flavor := range flavors {
+		if flavor.Name == name {
+			return &flavor, nil
+		}
+	}
+	return nil, fmt.Errorf("flavor %s: %w", name, cloud.ErrNotFound)
+}
+
+// ListInstanceTypes returns the flavors available to the project.
+func (c *Client) ListInstanceTypes(ctx context.Context) ([]cloud.InstanceType, error) {
+	flavors, err := c.listFlavors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]cloud.InstanceType, 0, len(flavors))
+	for _, f := range flavors {
+		types = append(types, cloud.InstanceType{
+			ID:         f.ID,
+			Name:       f.Name,
+			CPUs:       f.VCPUs,
+			Memory:     f.RAM << 20,
+			Authorized: true,
+		})
+	}
+	slices.SortFunc(types, func(a, b cloud.InstanceType) int {
+		if c := cmp.Compare(a.CPUs, b.CPUs); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Memory, b.Memory)
+	})
+	return types, nil
+}
+
+// ListTemplates returns the active images available to the project, except
+// snapshots.
+func (c *Client) ListTemplates(ctx context.Context) ([]cloud.Template, error) {
+	images, err := c.listImages(ctx, url.Values{"status": {"active"}})
+	if err != nil {
+		return nil, err
+	}
+	templates := make([]cloud.Template, 0, len(images))
+	for _, image := range images {
+		if image.ImageType == "snapshot" {
+			continue
+		}
+		templates = append(templates, cloud.Template{
+			ID:          image.ID,
+			Name:        image.Name,
+			Family:      image.OSDistro,
+			DefaultUser: image.OSAdminUser,
+			Visibility:  image.Visibility,
+			Size:        image.Size,
+		})
+	}
+	return templates, nil
+}
@@ -0,0 +1,93 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// apiError is an error response of an OpenStack API.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.Status, e.Message)
+}
+
+// newAPIError extracts the message of the error response's body, which the
+// services wrap in an object named after the kind of error, e.g.
+// {"itemNotFound": {"message": "…", "code": 404}}, or return as
+// {"NeutronError": {"message": "…"}} or as plain text.
+func newAPIError(status int, body []byte) *apiError {
+	var wrapped map[string]struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err == nil {
+		for _, inner := range wrapped {
+			if inner.Message != "" {
+				return &apiError{Status: status, Message: inner.Message}
+			}
+		}
+	}
+	return &apiError{Status: status, Message: strings.TrimSpace(string(body))}
+}
+
+// classified keeps the message of the wrapped error, but also matches the kind
+// of error it was classified as.
+type classified struct {
+	kind error
+	err  error
+}
+
+func (c *classified) Error() string {
+	return c.err.Error()
+}
+
+func (c *classified) Unwrap() []error {
+	return []error{c.kind, c.err}
+}
+
+// errorf formats an error like fmt.Errorf and classifies the error wrapped
+// according to the errors defined by the cloud package, if possible.
+func errorf(format string, args ...any) error {
+	return classify(fmt.Errorf(format, args...))
+}
+
+func classify(err error) error {
+	var kind error
+	var apiErr *apiError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound:
+		kind = cloud.ErrNotFound
+	case errors.As(err, &apiErr) && (apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusForbidden):
+		kind = cloud.ErrForbidden
+	case errors.As(err, &apiErr) && apiErr.Status == http.StatusTooManyRequests:
+		kind = cloud.ErrRateLimited
+	case errors.As(err, &apiErr) && apiErr.Status >= http.StatusInternalServerError,
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		kind = cloud.ErrUnavailable
+	default:
+		return err
+	}
+	return &classified{kind: kind, err: err}
+}
+
+// hasStatus tells whether the error is a response of the given status, e.g.
+// 409 for an action refused due to the state of the resource.
+func hasStatus(err error, status int) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
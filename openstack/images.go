@@ -0,0 +1,165 @@
+package openstack
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// image is a Glance image, which is either a template or, if taken of a
+// server, a snapshot.
+type image struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Size is given in bytes.
+	Size         int64     `json:"size"`
+	Created      time.Time `json:"created_at"`
+	Visibility   string    `json:"visibility"`
+	OSDistro     string    `json:"os_distro"`
+	OSAdminUser  string    `json:"os_admin_user"`
+	InstanceUUID string    `json:"instance_uuid"`
+	ImageType    string    `json:"image_type"`
+}
+
+// snapshotStates maps the statuses of images to the states of snapshots.
+var snapshotStates = map[string]string{
+	"queued":         "snapshotting",
+	"saving":         "snapshotting",
+	"uploading":      "snapshotting",
+	"importing":      "snapshotting",
+	"active":         "ready",
+	"killed":         "error",
+	"pending_delete": "deleting",
+	"deleted":        "deleted",
+}
+
+func (c *Client) getImage(ctx context.Context, id string) (*image, error) {
+	var image image
+	if _, err := c.request(ctx, http.MethodGet, "image", "/v2/images/"+id, nil, &image); err != nil {
+		return nil, errorf("get image %s: %w", id, err)
+	}
+	return &image, nil
+}
+
+// listImages returns the images matching the query, following the pages of
+// the listing.
+func (c *Client) listImages(ctx context.Context, query url.Values) ([]image, error) {
+	images := make([]image, 0)
+	path := "/v2/images?" + query.Encode()
+	for path != "" {
+		var resp struct {
+			Images []image `json:"images"`
+			Next   string  `json:"next"`
+		}
+		if _, err := c.request(ctx, http.MethodGet, "image", path, nil, &resp); err != nil {
+			return nil, errorf("list images: %w", err)
+		}
+		images = append(images, resp.Images...)
+		path = resp.Next
+	}
+	return images, nil
+}
+
+// CreateSnapshot creates an image of the server, waits until it is uploaded
+// and returns its ID.
+func (c *Client) CreateSnapshot(ctx context.Context, id string) (string, error) {
+	server, err := c.getServer(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		ImageID string `json:"image_id"`
+	}
+	name := fmt.Sprintf("%s-%s", server.Name, time.Now().UTC().Format("20060102-150405"))
+	if _, err := c.action(ctx, id, map[string]any{"createImage": map[string]string{"name": name}}, &resp); err != nil {
+		return "", errorf("create image of server %s: %w", id, err)
+	}
+	for {
+		image, err := c.getImage(ctx, resp.ImageID)
+		if err != nil {
+			return "", err
+		}
+		switch image.Status {
+		case "active":
+			return image.ID, nil
+		case "killed", "deleted", "pending_delete":
+			return "", fmt.Errorf("image %s of server %s is %s", image.ID, id, image.Status)
+		}
+		select {
+		case <-ctx.Done():
+			return "", errorf("wait for image %s: %w", image.ID, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// GetSnapshots returns the images taken of the server, oldest first.
+func (c *Client) GetSnapshots(ctx context.Context, instanceId string) ([]*cloud.Snapshot, error) {
+	images, err := c.listImages(ctx, url.Values{"instance_uuid": {instanceId}})
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*cloud.Snapshot, 0)
+	for _, image := range images {
+		if image.InstanceUUID == instanceId {
+			snapshots = append(snapshots, fromImage(&image))
+		}
+	}
+	slices.SortFunc(snapshots, func(a, b *cloud.Snapshot) int {
+		return cmp.Compare(a.Created.UnixNano(), b.Created.UnixNano())
+	})
+	return snapshots, nil
+}
+
+func (c *Client) GetSnapshot(ctx context.Context, id string) (*cloud.Snapshot, error) {
+	image, err := c.getImage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if image.InstanceUUID == "" {
+		return nil, fmt.Errorf("image %s is no snapshot: %w", id, cloud.ErrNotFound)
+	}
+	return fromImage(image), nil
+}
+
+// RevertToSnapshot rebuilds the server from the image taken of it.
+func (c *Client) RevertToSnapshot(ctx context.Context, instanceId, snapshotId string) error {
+	image, err := c.getImage(ctx, snapshotId)
+	if err != nil {
+		return err
+	}
+	if image.InstanceUUID != instanceId {
+		return fmt.Errorf("image %s of server %s: %w", snapshotId, instanceId, cloud.ErrNotFound)
+	}
+	return c.rebuild(ctx, instanceId, snapshotId, "")
+}
+
+func (c *Client) DeleteSnapshot(ctx context.Context, id string) error {
+	if _, err := c.request(ctx, http.MethodDelete, "image", "/v2/images/"+id, nil, nil); err != nil {
+		return errorf("delete image %s: %w", id, err)
+	}
+	return nil
+}
+
+func fromImage(image *image) *cloud.Snapshot {
+	state, ok := snapshotStates[image.Status]
+	if !ok {
+		state = strings.ToLower(image.Status)
+	}
+	return &cloud.Snapshot{
+		ID:         image.ID,
+		Name:       image.Name,
+		InstanceID: image.InstanceUUID,
+		Created:    image.Created,
+		Size:       (image.Size + 1<<30 - 1) >> 30,
+		State:      state,
+	}
+}
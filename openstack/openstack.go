@@ -0,0 +1,718 @@
+// Package openstack implements the cloud.Provider for OpenStack clouds, using
+// Keystone application credentials. Instances are Nova servers in the region
+// given as zone, whose metadata serves as labels, templates and snapshots are
+// Glance images, and security groups are managed through Neutron.
+package openstack
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+const (
+	// callTimeout is how long a single request to an OpenStack API may take.
+	callTimeout = 30 * time.Second
+	// computeVersion is the Nova API microversion requested, which embeds the
+	// flavor into servers (2.47), shows the events of instance actions to
+	// non-admins (2.51) and replaces the user data on rebuild (2.57).
+	computeVersion = "2.57"
+	// consoleTTL is how long console URLs are valid, which is the default
+	// token TTL of the Nova console proxy.
+	consoleTTL = 10 * time.Minute
+)
+
+// pollInterval is how often servers, images and actions are polled while
+// waiting for them.
+var pollInterval = 2 * time.Second
+
+// Client is the cloud.Provider for OpenStack.
+type Client struct {
+	// Endpoint is the URL of Keystone, without the version.
+	Endpoint string
+	Region   string
+	// Key and Secret are the ID and secret of an application credential.
+	Key    string
+	Secret string
+	http   *http.Client
+}
+
+var _ cloud.Provider = (*Client)(nil)
+
+func NewClient(endpoint, region, key, secret string) *Client {
+	return &Client{
+		Endpoint: strings.TrimSuffix(strings.TrimSuffix(endpoint, "/"), "/v3"),
+		Region:   region,
+		Key:      key,
+		Secret:   secret,
+		http:     &http.Client{Timeout: callTimeout},
+	}
+}
+
+// NewProvider is the cloud.Factory for OpenStack.
+func NewProvider(creds cloud.Credentials) cloud.Provider {
+	return NewClient(creds.Endpoint, creds.Zone, creds.Key, creds.Secret)
+}
+
+type sessionKey struct {
+	endpoint, key, secret string
+}
+
+// sessions holds a session per credentials, shared by all clients using them.
+var sessions sync.Map
+
+// session is a Keystone token along with the catalog of services it was
+// issued with.
+type session struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	catalog []service
+}
+
+type service struct {
+	Type      string `json:"type"`
+	Endpoints []struct {
+		Interface string `json:"interface"`
+		Region    string `json:"region_id"`
+		URL       string `json:"url"`
+	} `json:"endpoints"`
+}
+
+func (c *Client) session() *session {
+	s, _ := sessions.LoadOrStore(sessionKey{c.Endpoint, c.Key, c.Secret}, &session{})
+	return s.(*session)
+}
+
+// authenticate returns the session's token and catalog, issuing a new token if
+// there is none or it is about to expire.
+func (c *Client) authenticate(ctx context.Context) (string, []service, error) {
+	s := c.session()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > time.Minute {
+		return s.token, s.catalog, nil
+	}
+	request := map[string]any{"auth": map[string]any{"identity": map[string]any{
+		"methods":                []string{"application_credential"},
+		"application_credential": map[string]string{"id": c.Key, "secret": c.Secret},
+	}}}
+	var resp struct {
+		Token struct {
+			ExpiresAt time.Time `json:"expires_at"`
+			Catalog   []service `json:"catalog"`
+		} `json:"token"`
+	}
+	header, err := c.do(ctx, http.MethodPost, c.Endpoint+"/v3/auth/tokens", "", request, &resp)
+	if err != nil {
+		return "", nil, errorf("authenticate at %s: %w", c.Endpoint, err)
+	}
+	s.token = header.Get("X-Subject-Token")
+	s.expires = resp.Token.ExpiresAt
+	s.catalog = resp.Token.Catalog
+	return s.token, s.catalog, nil
+}
+
+// discard drops the token if the session still holds it, e.g. because it was
+// revoked.
+func (s *session) discard(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// serviceURL returns the URL of the public endpoint of the service type in the
+// client's region.
+func (c *Client) serviceURL(catalog []service, serviceType string) (string, error) {
+	for _, service := range catalog {
+		if service.Type != serviceType {
+			continue
+		}
+		for _, endpoint := range service.Endpoints {
+			if endpoint.Interface == "public" && endpoint.Region == c.Region {
+				return strings.TrimSuffix(endpoint.URL, "/"), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no public %s endpoint in region %s", serviceType, c.Region)
+}
+
+// request calls the service of the given type, e.g. "compute", authenticating
+// once more if the token was rejected. The body and result are encoded as and
+// decoded from JSON, if given.
+func (c *Client) request(ctx context.Context, method, serviceType, path string, body, result any) (http.Header, error) {
+	for attempt := 0; ; attempt++ {
+		token, catalog, err := c.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		base, err := c.serviceURL(catalog, serviceType)
+		if err != nil {
+			return nil, err
+		}
+		header, err := c.do(ctx, method, base+path, token, body, result)
+		if attempt == 0 && hasStatus(err, http.StatusUnauthorized) {
+			c.session().discard(token)
+			continue
+		}
+		return header, err
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, url, token string, body, result any) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("encode request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Auth-Token", token)
+	}
+	req.Header.Set("X-OpenStack-Nova-API-Version", computeVersion)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response of %s %s: %w", method, url, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.Header, newAPIError(resp.StatusCode, data)
+	}
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return nil, fmt.Errorf("decode response of %s %s: %w", method, url, err)
+		}
+	}
+	return resp.Header, nil
+}
+
+type server struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Status    string            `json:"status"`
+	TaskState string            `json:"OS-EXT-STS:task_state"`
+	Metadata  map[string]string `json:"metadata"`
+	Addresses map[string][]struct {
+		Addr    string `json:"addr"`
+		Version int    `json:"version"`
+	} `json:"addresses"`
+	Created time.Time `json:"created"`
+	Flavor  struct {
+		OriginalName string `json:"original_name"`
+		Disk         int64  `json:"disk"`
+	} `json:"flavor"`
+	Image          imageRef           `json:"image"`
+	SecurityGroups []securityGroupRef `json:"security_groups"`
+	KeyName        string             `json:"key_name"`
+}
+
+// securityGroupRef is a security group of a server, given by its name.
+type securityGroupRef struct {
+	Name string `json:"name"`
+}
+
+// imageRef is the image a server was booted from, which Nova gives as an empty
+// string for servers booted from volumes.
+type imageRef struct {
+	ID string `json:"id"`
+}
+
+func (r *imageRef) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return nil
+	}
+	type plain imageRef
+	return json.Unmarshal(data, (*plain)(r))
+}
+
+func (c *Client) GetInstances(ctx context.Context) ([]*cloud.Instance, error) {
+	var resp struct {
+		Servers []server `json:"servers"`
+	}
+	if _, err := c.request(ctx, http.MethodGet, "compute", "/servers/detail", nil, &resp); err != nil {
+		return nil, errorf("list servers: %w", err)
+	}
+	instances := make([]*cloud.Instance, 0, len(resp.Servers))
+	for _, server := range resp.Servers {
+		instances = append(instances, fromServer(&server, c.Region))
+	}
+	return instances, nil
+}
+
+func (c *Client) GetInstance(ctx context.Context, id string) (*cloud.Instance, error) {
+	server, err := c.getServer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return fromServer(server, c.Region), nil
+}
+
+func (c *Client) getServer(ctx context.Context, id string) (*server, error) {
+	var resp struct {
+		Server server `json:"server"`
+	}
+	if _, err := c.request(ctx, http.MethodGet, "compute", "/servers/"+id, nil, &resp); err != nil {
+		return nil, errorf("get server %s: %w", id, err)
+	}
+	return &resp.Server, nil
+}
+
+// GetInstanceDetail returns the instance with the name of its image resolved.
+// Images deleted in the meantime are left unresolved.
+func (c *Client) GetInstanceDetail(ctx context.Context, id string) (*cloud.Instance, error) {
+	instance, err := c.GetInstance(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Template != nil {
+		image, err := c.getImage(ctx, instance.Template.ID)
+		if err != nil && !errors.Is(err, cloud.ErrNotFound) {
+			return nil, err
+		} else if err == nil {
+			instance.Template.Name = image.Name
+		}
+	}
+	return instance, nil
+}
+
+// GetInstanceTypeName returns the name of the server's flavor.
+func (c *Client) GetInstanceTypeName(ctx context.Context, id string) (string, error) {
+	server, err := c.getServer(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if server.Flavor.OriginalName == "" {
+		return "", fmt.Errorf("server %s lacks a flavor", id)
+	}
+	return server.Flavor.OriginalName, nil
+}
+
+// CreateInstance creates a server attached to the project's network. Its disk
+// size is given by the flavor, so the one of the spec is ignored. Nova
+// authorizes a single key pair per server, which is the first SSH key given.
+func (c *Client) CreateInstance(ctx context.Context, spec cloud.InstanceSpec) (*cloud.Instance, error) {
+	flavor, err := c.findFlavor(ctx, spec.InstanceType)
+	if err != nil {
+		return nil, err
+	}
+	labels := spec.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	request := map[string]any{
+		"name":      spec.Name,
+		"imageRef":  spec.TemplateID,
+		"flavorRef": flavor.ID,
+		"networks":  "auto",
+		"metadata":  labels,
+	}
+	if len(spec.SSHKeys) > 0 {
+		request["key_name"] = spec.SSHKeys[0]
+	}
+	if spec.UserData != "" {
+		request["user_data"] = base64.StdEncoding.EncodeToString([]byte(spec.UserData))
+	}
+	if len(spec.SecurityGroups) > 0 {
+		groups := make([]map[string]string, 0, len(spec.SecurityGroups))
+		for _, group := range spec.SecurityGroups {
+			groups = append(groups, map[string]string{"name": group})
+		}
+		request["security_groups"] = groups
+	}
+	var resp struct {
+		Server struct {
+			ID string `json:"id"`
+		} `json:"server"`
+	}
+	_, err = c.request(ctx, http.MethodPost, "compute", "/servers", map[string]any{"server": request}, &resp)
+	if err != nil {
+		return nil, errorf("create server %s: %w", spec.Name, err)
+	}
+	return c.GetInstance(ctx, resp.Server.ID)
+}
+
+// EnsureSSHKey imports the public key as key pair under the name, unless a key
+// pair of that name exists. Key pairs belong to the user of the application
+// credential rather than to a region.
+func (c *Client) EnsureSSHKey(ctx context.Context, name, publicKey string) error {
+	_, err := c.request(ctx, http.MethodGet, "compute", "/os-keypairs/"+url.PathEscape(name), nil, nil)
+	if err == nil {
+		return nil
+	} else if err := errorf("get key pair %s: %w", name, err); !errors.Is(err, cloud.ErrNotFound) {
+		return err
+	}
+	keypair := map[string]string{"name": name, "public_key": publicKey}
+	_, err = c.request(ctx, http.MethodPost, "compute", "/os-keypairs", map[string]any{"keypair": keypair}, nil)
+	if err != nil {
+		return errorf("import key pair %s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) DeleteSSHKey(ctx context.Context, name string) error {
+	_, err := c.request(ctx, http.MethodDelete, "compute", "/os-keypairs/"+url.PathEscape(name), nil, nil)
+	if err != nil {
+		return errorf("delete key pair %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteInstance destroys the instance and waits until it is gone.
+func (c *Client) DeleteInstance(ctx context.Context, id string) error {
+	if _, err := c.request(ctx, http.MethodDelete, "compute", "/servers/"+id, nil, nil); err != nil {
+		return errorf("delete server %s: %w", id, err)
+	}
+	err := c.awaitServer(ctx, id, func(s *server) (bool, error) { return s.Status == "DELETED", nil })
+	if err != nil && !errors.Is(err, cloud.ErrNotFound) {
+		return fmt.Errorf("wait for deletion of server %s: %w", id, err)
+	}
+	return nil
+}
+
+// UpdateInstanceLabels replaces the metadata of the server.
+func (c *Client) UpdateInstanceLabels(ctx context.Context, id string, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	_, err := c.request(ctx, http.MethodPut, "compute", "/servers/"+id+"/metadata",
+		map[string]any{"metadata": labels}, nil)
+	if err != nil {
+		return errorf("update metadata of server %s: %w", id, err)
+	}
+	return nil
+}
+
+// StartInstance requests the instance to be started and returns the ID of the
+// operation, which can be awaited using WaitForOperation.
+func (c *Client) StartInstance(ctx context.Context, id string) (string, error) {
+	opId, err := c.action(ctx, id, map[string]any{"os-start": nil}, nil)
+	if err != nil {
+		return "", errorf("start server %s: %w", id, err)
+	}
+	return opId, nil
+}
+
+// StopInstance requests the instance to be stopped and returns the ID of the
+// operation, which can be awaited using WaitForOperation.
+func (c *Client) StopInstance(ctx context.Context, id string) (string, error) {
+	opId, err := c.action(ctx, id, map[string]any{"os-stop": nil}, nil)
+	if err != nil {
+		return "", errorf("stop server %s: %w", id, err)
+	}
+	return opId, nil
+}
+
+// RebootInstance requests the instance to be rebooted and returns the ID of the
+// operation, which can be awaited using WaitForOperation.
+func (c *Client) RebootInstance(ctx context.Context, id string) (string, error) {
+	opId, err := c.action(ctx, id, map[string]any{"reboot": map[string]string{"type": "SOFT"}}, nil)
+	if err != nil {
+		return "", errorf("reboot server %s: %w", id, err)
+	}
+	return opId, nil
+}
+
+// action requests the action on the server and returns the ID of the
+// operation, made up of the server ID and the ID of the request, by which Nova
+// records the action.
+func (c *Client) action(ctx context.Context, id string, action, result any) (string, error) {
+	header, err := c.request(ctx, http.MethodPost, "compute", "/servers/"+id+"/action", action, result)
+	if err != nil {
+		return "", err
+	}
+	requestId := header.Get("X-Openstack-Request-Id")
+	if requestId == "" {
+		requestId = header.Get("X-Compute-Request-Id")
+	}
+	if requestId == "" {
+		return "", errors.New("response lacks request ID")
+	}
+	return id + "/" + requestId, nil
+}
+
+// WaitForOperation waits until all events of the action recorded for the
+// operation finished, or returns an error if one of them failed or they did
+// not finish within the timeout.
+func (c *Client) WaitForOperation(ctx context.Context, id string, timeout time.Duration) error {
+	serverId, requestId, ok := strings.Cut(id, "/")
+	if !ok {
+		return fmt.Errorf("invalid operation ID %s", id)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		var resp struct {
+			Action struct {
+				Events []struct {
+					Event      string `json:"event"`
+					FinishTime string `json:"finish_time"`
+					Result     string `json:"result"`
+				} `json:"events"`
+			} `json:"instanceAction"`
+		}
+		_, err := c.request(ctx, http.MethodGet, "compute",
+			"/servers/"+serverId+"/os-instance-actions/"+requestId, nil, &resp)
+		if err != nil {
+			return errorf("wait for operation %s: %w", id, err)
+		}
+		done := len(resp.Action.Events) > 0
+		for _, event := range resp.Action.Events {
+			if event.Result == "Error" {
+				return fmt.Errorf("operation %s failed in %s", id, event.Event)
+			}
+			done = done && event.FinishTime != ""
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errorf("wait for operation %s: %w", id, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// ForceStopInstance stops the instance and waits for it to be stopped. Nova
+// powers servers off that do not shut down in time, but the stop is repeated
+// if the server is still not stopped within the timeout.
+func (c *Client) ForceStopInstance(ctx context.Context, id string, timeout time.Duration) error {
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		_, err := c.action(attemptCtx, id, map[string]any{"os-stop": nil}, nil)
+		if err == nil || hasStatus(err, http.StatusConflict) {
+			err = c.awaitServer(attemptCtx, id, func(s *server) (bool, error) {
+				return s.Status == "SHUTOFF" && s.TaskState == "", nil
+			})
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errorf("force stop server %s: %w", id, lastErr)
+}
+
+// ResetInstance rebuilds the server from the image, or from the image it was
+// booted from if templateId is empty, and waits until it is done.
+func (c *Client) ResetInstance(ctx context.Context, id, templateId, userData string) (string, error) {
+	if templateId == "" {
+		server, err := c.getServer(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		if server.Image.ID == "" {
+			return "", fmt.Errorf("server %s was not booted from an image", id)
+		}
+		templateId = server.Image.ID
+	}
+	if err := c.rebuild(ctx, id, templateId, userData); err != nil {
+		return "", err
+	}
+	return templateId, nil
+}
+
+// rebuild reinstalls the server from the image, replacing its user data unless
+// empty.
+func (c *Client) rebuild(ctx context.Context, id, imageId, userData string) error {
+	rebuild := map[string]string{"imageRef": imageId}
+	if userData != "" {
+		rebuild["user_data"] = base64.StdEncoding.EncodeToString([]byte(userData))
+	}
+	_, err := c.action(ctx, id, map[string]any{"rebuild": rebuild}, nil)
+	if err != nil {
+		return errorf("rebuild server %s from image %s: %w", id, imageId, err)
+	}
+	if err := c.awaitServer(ctx, id, settled); err != nil {
+		return fmt.Errorf("wait for rebuild of server %s: %w", id, err)
+	}
+	return nil
+}
+
+// ScaleInstance resizes the server to the flavor of the given name and
+// confirms the resize once it is ready.
+func (c *Client) ScaleInstance(ctx context.Context, id, instanceType string) error {
+	flavor, err := c.findFlavor(ctx, instanceType)
+	if err != nil {
+		return err
+	}
+	_, err = c.action(ctx, id, map[string]any{"resize": map[string]string{"flavorRef": flavor.ID}}, nil)
+	if err != nil {
+		return errorf("resize server %s to %s: %w", id, instanceType, err)
+	}
+	err = c.awaitServer(ctx, id, func(s *server) (bool, error) {
+		if _, err := settled(s); err != nil {
+			return false, err
+		}
+		return s.Status == "VERIFY_RESIZE", nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for resize of server %s: %w", id, err)
+	}
+	if _, err := c.action(ctx, id, map[string]any{"confirmResize": nil}, nil); err != nil {
+		return errorf("confirm resize of server %s: %w", id, err)
+	}
+	if err := c.awaitServer(ctx, id, settled); err != nil {
+		return fmt.Errorf("wait for confirmation of resize of server %s: %w", id, err)
+	}
+	return nil
+}
+
+// ResetInstancePassword is not supported, since Nova can only set passwords
+// through a guest agent and never reveals them.
+func (c *Client) ResetInstancePassword(ctx context.Context, id string) error {
+	return fmt.Errorf("reset password of server %s: %w", id, cloud.ErrUnsupported)
+}
+
+// RevealInstancePassword is not supported, see ResetInstancePassword.
+func (c *Client) RevealInstancePassword(ctx context.Context, id string) (string, error) {
+	return "", fmt.Errorf("reveal password of server %s: %w", id, cloud.ErrUnsupported)
+}
+
+// GetConsoleURL returns the URL of the server's noVNC console.
+func (c *Client) GetConsoleURL(ctx context.Context, id string) (*cloud.ConsoleURL, error) {
+	var resp struct {
+		Console struct {
+			URL string `json:"url"`
+		} `json:"remote_console"`
+	}
+	request := map[string]any{"remote_console": map[string]string{"protocol": "vnc", "type": "novnc"}}
+	if _, err := c.request(ctx, http.MethodPost, "compute", "/servers/"+id+"/remote-consoles", request, &resp); err != nil {
+		return nil, errorf("get console of server %s: %w", id, err)
+	}
+	return &cloud.ConsoleURL{URL: resp.Console.URL, Expires: time.Now().Add(consoleTTL)}, nil
+}
+
+// awaitServer polls the server until done reports true or fails.
+func (c *Client) awaitServer(ctx context.Context, id string, done func(*server) (bool, error)) error {
+	for {
+		server, err := c.getServer(ctx, id)
+		if err != nil {
+			return err
+		}
+		if ok, err := done(server); ok || err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errorf("wait for server %s: %w", id, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// transitional are the statuses of servers with a task running.
+var transitional = []string{"BUILD", "REBUILD", "RESIZE", "REBOOT", "HARD_REBOOT", "MIGRATING"}
+
+// settled tells whether no task is running on the server anymore, failing if
+// the server ended up in an error.
+func settled(s *server) (bool, error) {
+	if s.Status == "ERROR" {
+		return false, fmt.Errorf("server %s is in error", s.ID)
+	}
+	return s.TaskState == "" && !slices.Contains(transitional, s.Status), nil
+}
+
+// states maps the statuses of servers to the states of instances.
+var states = map[string]string{
+	"ACTIVE":       "running",
+	"SHUTOFF":      "stopped",
+	"BUILD":        "starting",
+	"REBOOT":       "starting",
+	"HARD_REBOOT":  "starting",
+	"MIGRATING":    "migrating",
+	"ERROR":        "error",
+	"DELETED":      "destroyed",
+	"SOFT_DELETED": "destroyed",
+}
+
+// taskStates maps the tasks that change the power state of servers to the
+// states of instances.
+var taskStates = map[string]string{
+	"powering-off": "stopping",
+	"powering-on":  "starting",
+	"deleting":     "destroying",
+}
+
+func fromServer(s *server, region string) *cloud.Instance {
+	state, ok := taskStates[s.TaskState]
+	if !ok {
+		state, ok = states[s.Status]
+	}
+	if !ok {
+		state = strings.ToLower(s.Status)
+	}
+	labels := s.Metadata
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	instance := &cloud.Instance{
+		ID:       s.ID,
+		Name:     s.Name,
+		Labels:   labels,
+		State:    state,
+		Zone:     region,
+		Created:  s.Created,
+		DiskSize: s.Flavor.Disk,
+	}
+	networks := make([]string, 0, len(s.Addresses))
+	for network := range s.Addresses {
+		networks = append(networks, network)
+	}
+	slices.Sort(networks)
+	for _, network := range networks {
+		for _, address := range s.Addresses[network] {
+			if address.Version == 4 && instance.IP == "" {
+				instance.IP = address.Addr
+			} else if address.Version == 6 && instance.IPv6 == "" {
+				instance.IPv6 = address.Addr
+			}
+		}
+	}
+	if s.Flavor.OriginalName != "" {
+		instance.InstanceType = &cloud.Reference{Name: s.Flavor.OriginalName}
+	}
+	if s.Image.ID != "" {
+		instance.Template = &cloud.Reference{ID: s.Image.ID}
+	}
+	for _, group := range s.SecurityGroups {
+		instance.SecurityGroups = append(instance.SecurityGroups, cloud.Reference{Name: group.Name})
+	}
+	if s.KeyName != "" {
+		instance.SSHKeys = []string{s.KeyName}
+	}
+	return instance
+}
@@ -0,0 +1,259 @@
+package openstack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// standIn serves the parts of Keystone and Nova the client uses, keeping the
+// servers in memory. Actions finish the second time they are polled.
+type standIn struct {
+	*httptest.Server
+	mu      sync.Mutex
+	tokens  int
+	revoked string
+	servers map[string]*server
+	actions map[string]int
+}
+
+func newStandIn(t *testing.T) *standIn {
+	s := &standIn{servers: make(map[string]*server), actions: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/auth/tokens", s.issueToken)
+	mux.HandleFunc("GET /compute/v2.1/servers/detail", s.authorized(s.listServers))
+	mux.HandleFunc("GET /compute/v2.1/servers/{id}", s.authorized(s.getServer))
+	mux.HandleFunc("PUT /compute/v2.1/servers/{id}/metadata", s.authorized(s.replaceMetadata))
+	mux.HandleFunc("POST /compute/v2.1/servers/{id}/action", s.authorized(s.action))
+	mux.HandleFunc("GET /compute/v2.1/servers/{id}/os-instance-actions/{request}", s.authorized(s.getAction))
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) issueToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Auth struct {
+			Identity struct {
+				Credential struct {
+					ID     string `json:"id"`
+					Secret string `json:"secret"`
+				} `json:"application_credential"`
+			} `json:"identity"`
+		} `json:"auth"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Auth.Identity.Credential.Secret != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error": {"code": 401, "message": "The request you have made requires authentication."}}`)
+		return
+	}
+	s.mu.Lock()
+	s.tokens++
+	token := fmt.Sprintf("token-%d", s.tokens)
+	s.mu.Unlock()
+	w.Header().Set("X-Subject-Token", token)
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, `{"token": {"expires_at": %q, "catalog": [
+		{"type": "compute", "endpoints": [
+			{"interface": "public", "region_id": "RegionOne", "url": "%s/compute/v2.1"},
+			{"interface": "internal", "region_id": "RegionOne", "url": "http://10.0.0.1/compute/v2.1"},
+			{"interface": "public", "region_id": "RegionTwo", "url": "http://10.0.0.2/compute/v2.1"}
+		]}
+	]}}`, time.Now().Add(time.Hour).Format(time.RFC3339), s.URL)
+}
+
+func (s *standIn) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Auth-Token")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if token == "" || token == s.revoked {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if id := r.PathValue("id"); id != "" && s.servers[id] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"itemNotFound": {"code": 404, "message": "Instance %s could not be found."}}`, id)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *standIn) listServers(w http.ResponseWriter, r *http.Request) {
+	servers := make([]*server, 0)
+	for _, server := range s.servers {
+		servers = append(servers, server)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"servers": servers})
+}
+
+func (s *standIn) getServer(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"server": s.servers[r.PathValue("id")]})
+}
+
+func (s *standIn) replaceMetadata(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	s.servers[r.PathValue("id")].Metadata = body.Metadata
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *standIn) action(w http.ResponseWriter, r *http.Request) {
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+	server := s.servers[r.PathValue("id")]
+	if _, ok := body["os-stop"]; !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if server.Status != "ACTIVE" {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"conflictingRequest": {"code": 409, "message": "Cannot 'stop' instance while it is in vm_state stopped"}}`)
+		return
+	}
+	server.TaskState = "powering-off"
+	request := fmt.Sprintf("req-%d", len(s.actions))
+	s.actions[request] = 0
+	w.Header().Set("X-Openstack-Request-Id", request)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *standIn) getAction(w http.ResponseWriter, r *http.Request) {
+	request := r.PathValue("request")
+	polls, ok := s.actions[request]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.actions[request]++
+	finish := "null"
+	if polls > 0 {
+		finish = `"2026-01-12T10:00:00.000000"`
+		server := s.servers[r.PathValue("id")]
+		server.Status, server.TaskState = "SHUTOFF", ""
+	}
+	fmt.Fprintf(w, `{"instanceAction": {"action": "stop", "request_id": %q, "events": [
+		{"event": "compute_stop_instance", "start_time": "2026-01-12T09:59:58.000000", "finish_time": %s, "result": null}
+	]}}`, request, finish)
+}
+
+func (s *standIn) add(id, name, status string, metadata map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	server := &server{ID: id, Name: name, Status: status, Metadata: metadata, Created: time.Now().UTC()}
+	server.Flavor.OriginalName = "m1.small"
+	server.Flavor.Disk = 20
+	server.Image.ID = "image-1"
+	server.Addresses = map[string][]struct {
+		Addr    string `json:"addr"`
+		Version int    `json:"version"`
+	}{"school": {{Addr: "2001:db8::5", Version: 6}, {Addr: "10.1.0.5", Version: 4}}}
+	s.servers[id] = server
+}
+
+func TestGetInstances(t *testing.T) {
+	s := newStandIn(t)
+	s.add("a1", "alice-linux", "ACTIVE", map[string]string{"owner": "alice"})
+	s.add("b2", "bob-linux", "SHUTOFF", nil)
+	c := NewClient(s.URL+"/v3/", "RegionOne", "cred", "secret")
+
+	instances, err := c.GetInstances(context.Background())
+	if err != nil {
+		t.Fatalf("get instances: %v", err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("expected 2 instances, got %d", len(instances))
+	}
+	instance, err := NewClient(s.URL, "RegionOne", "cred", "secret").GetInstance(context.Background(), "a1")
+	if err != nil {
+		t.Fatalf("get instance: %v", err)
+	}
+	if instance.State != "running" || instance.Labels["owner"] != "alice" || instance.IP != "10.1.0.5" ||
+		instance.IPv6 != "2001:db8::5" || instance.Zone != "RegionOne" || instance.InstanceType.Name != "m1.small" {
+		t.Errorf("unexpected instance %+v", instance)
+	}
+	if s.tokens != 1 {
+		t.Errorf("expected the token to be reused, issued %d", s.tokens)
+	}
+	zones, err := c.ListZones(context.Background())
+	if err != nil || strings.Join(zones, ",") != "RegionOne,RegionTwo" {
+		t.Errorf("expected regions as zones, got %v (%v)", zones, err)
+	}
+}
+
+func TestStopInstance(t *testing.T) {
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+	s := newStandIn(t)
+	s.add("a1", "alice-linux", "ACTIVE", nil)
+	c := NewClient(s.URL, "RegionOne", "cred", "secret")
+	ctx := context.Background()
+
+	opId, err := c.StopInstance(ctx, "a1")
+	if err != nil {
+		t.Fatalf("stop instance: %v", err)
+	}
+	if instance, _ := c.GetInstance(ctx, "a1"); instance.State != "stopping" {
+		t.Errorf("expected instance to be stopping, was %s", instance.State)
+	}
+	if err := c.WaitForOperation(ctx, opId, time.Second); err != nil {
+		t.Fatalf("wait for operation %s: %v", opId, err)
+	}
+	if instance, _ := c.GetInstance(ctx, "a1"); instance.State != "stopped" {
+		t.Errorf("expected instance to be stopped, was %s", instance.State)
+	}
+	if err := c.ForceStopInstance(ctx, "a1", time.Second); err != nil {
+		t.Errorf("expected forced stop of stopped instance to succeed, got %v", err)
+	}
+
+	if err := c.UpdateInstanceLabels(ctx, "a1", map[string]string{"owner": "bob"}); err != nil {
+		t.Fatalf("update labels: %v", err)
+	}
+	if instance, _ := c.GetInstance(ctx, "a1"); instance.Labels["owner"] != "bob" {
+		t.Errorf("expected metadata to be replaced, got %v", instance.Labels)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s := newStandIn(t)
+	s.add("a1", "alice-linux", "ACTIVE", nil)
+	ctx := context.Background()
+	c := NewClient(s.URL, "RegionOne", "cred", "secret")
+
+	_, err := c.GetInstance(ctx, "unknown")
+	if !errors.Is(err, cloud.ErrNotFound) || !strings.Contains(err.Error(), "could not be found") {
+		t.Errorf("expected not found error with message, got %v", err)
+	}
+	if _, err := NewClient(s.URL, "RegionOne", "cred", "wrong").GetInstances(ctx); !errors.Is(err, cloud.ErrForbidden) {
+		t.Errorf("expected invalid credentials to be forbidden, got %v", err)
+	}
+	if _, err := NewClient(s.URL, "RegionThree", "cred", "secret").GetInstances(ctx); err == nil {
+		t.Errorf("expected region without endpoint to fail")
+	}
+	if err := c.ResetInstancePassword(ctx, "a1"); !errors.Is(err, cloud.ErrUnsupported) {
+		t.Errorf("expected password reset to be unsupported, got %v", err)
+	}
+
+	s.mu.Lock()
+	s.revoked = "token-1"
+	s.mu.Unlock()
+	if _, err := c.GetInstance(ctx, "a1"); err != nil {
+		t.Errorf("expected revoked token to be renewed, got %v", err)
+	}
+	if s.tokens != 2 {
+		t.Errorf("expected a second token, issued %d", s.tokens)
+	}
+}
@@ -0,0 +1,130 @@
+package openstack
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/composed-ch/cloud-castle-backend/cloud"
+)
+
+// instanceSecurityGroupPrefix is prepended to the instance ID to name the
+// security group holding the rules requested for that instance.
+const instanceSecurityGroupPrefix = "cloud-castle-"
+
+type securityGroup struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// findSecurityGroup returns the security group of the given name, or nil if
+// there is none.
+func (c *Client) findSecurityGroup(ctx context.Context, name string) (*securityGroup, error) {
+	var resp struct {
+		Groups []securityGroup `json:"security_groups"`
+	}
+	path := "/v2.0/security-groups?" + url.Values{"name": {name}}.Encode()
+	if _, err := c.request(ctx, http.MethodGet, "network", path, nil, &resp); err != nil {
+		return nil, errorf("list security groups: %w", err)
+	}
+	for _, group := range resp.Groups {
+		if group.Name == name {
+			return &group, nil
+		}
+	}
+	return nil, nil
+}
+
+// EnsureInstanceSecurityGroup returns the ID of the instance's own security
+// group, creating it and adding it to the server if necessary.
+func (c *Client) EnsureInstanceSecurityGroup(ctx context.Context, instanceId string) (string, error) {
+	name := instanceSecurityGroupPrefix + instanceId
+	group, err := c.findSecurityGroup(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if group == nil {
+		var resp struct {
+			Group securityGroup `json:"security_group"`
+		}
+		request := map[string]any{"security_group": map[string]string{
+			"name":        name,
+			"description": "Rules requested for instance " + instanceId,
+		}}
+		if _, err := c.request(ctx, http.MethodPost, "network", "/v2.0/security-groups", request, &resp); err != nil {
+			return "", errorf("create security group %s: %w", name, err)
+		}
+		group = &resp.Group
+	}
+	server, err := c.getServer(ctx, instanceId)
+	if err != nil {
+		return "", err
+	}
+	attached := slices.ContainsFunc(server.SecurityGroups, func(g securityGroupRef) bool {
+		return g.Name == name
+	})
+	if !attached {
+		_, err := c.request(ctx, http.MethodPost, "compute", "/servers/"+instanceId+"/action",
+			map[string]any{"addSecurityGroup": map[string]string{"name": group.ID}}, nil)
+		if err != nil {
+			return "", errorf("add security group %s to server %s: %w", name, instanceId, err)
+		}
+	}
+	return group.ID, nil
+}
+
+// AddIngressRule adds the rule to the security group and returns the ID of the
+// rule.
+func (c *Client) AddIngressRule(ctx context.Context, groupId string, rule cloud.IngressRule) (string, error) {
+	etherType := "IPv4"
+	if strings.Contains(rule.Network, ":") {
+		etherType = "IPv6"
+	}
+	var resp struct {
+		Rule struct {
+			ID string `json:"id"`
+		} `json:"security_group_rule"`
+	}
+	request := map[string]any{"security_group_rule": map[string]any{
+		"security_group_id": groupId,
+		"direction":         "ingress",
+		"ethertype":         etherType,
+		"protocol":          rule.Protocol,
+		"port_range_min":    rule.Port,
+		"port_range_max":    rule.Port,
+		"remote_ip_prefix":  rule.Network,
+		"description":       rule.Description,
+	}}
+	if _, err := c.request(ctx, http.MethodPost, "network", "/v2.0/security-group-rules", request, &resp); err != nil {
+		return "", errorf("add rule to security group %s: %w", groupId, err)
+	}
+	return resp.Rule.ID, nil
+}
+
+func (c *Client) DeleteSecurityGroupRule(ctx context.Context, groupId, ruleId string) error {
+	if _, err := c.request(ctx, http.MethodDelete, "network", "/v2.0/security-group-rules/"+ruleId, nil, nil); err != nil {
+		return errorf("delete rule %s from security group %s: %w", ruleId, groupId, err)
+	}
+	return nil
+}
+
+// DeleteInstanceSecurityGroup removes the instance's own security group, if
+// there is one, from the server and deletes it.
+func (c *Client) DeleteInstanceSecurityGroup(ctx context.Context, instanceId string) error {
+	name := instanceSecurityGroupPrefix + instanceId
+	group, err := c.findSecurityGroup(ctx, name)
+	if err != nil || group == nil {
+		return err
+	}
+	_, err = c.request(ctx, http.MethodPost, "compute", "/servers/"+instanceId+"/action",
+		map[string]any{"removeSecurityGroup": map[string]string{"name": group.ID}}, nil)
+	if err != nil && !hasStatus(err, http.StatusNotFound) {
+		return errorf("remove security group %s from server %s: %w", name, instanceId, err)
+	}
+	if _, err := c.request(ctx, http.MethodDelete, "network", "/v2.0/security-groups/"+group.ID, nil, nil); err != nil {
+		return errorf("delete security group %s: %w", name, err)
+	}
+	return nil
+}
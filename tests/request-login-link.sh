@@ -0,0 +1,3 @@
+#!/usr/bin/bash
+
+curl -v -X POST 'https://backend.cloud-castle.ch/login/link' -d '{ "email": "patrick.bucher@sluz.ch" }'